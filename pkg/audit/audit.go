@@ -0,0 +1,139 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"sort"
+	"time"
+
+	v1net "k8s.io/api/networking/v1"
+)
+
+// Operations on the generated policies.
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Triggers of the generated policy changes which aren't cluster events.
+const (
+	TriggerDriftCorrection   = "DriftCorrection"
+	TriggerGarbageCollection = "GarbageCollection"
+	TriggerNamespaceMapping  = "NamespaceMappingChanged"
+)
+
+// Trigger is what caused a generated policy to change, the cluster event of a pod or policy change
+// (Added, Updated or Deleted), or one of the Trigger* causes.
+type Trigger struct {
+	Event   string `json:"event"`
+	ObjType string `json:"objType,omitempty"`
+	ObjID   string `json:"objID,omitempty"`
+}
+
+// Record is the audit record of a change of a generated policy.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	// OriginatingObjID is the ObjID of the original policy the policy is generated from
+	OriginatingObjID string `json:"originatingObjID"`
+	// Cluster is the cluster the generated policy is written to
+	Cluster      string   `json:"cluster"`
+	Namespace    string   `json:"namespace"`
+	Name         string   `json:"name"`
+	AddedCIDRs   []string `json:"addedCIDRs"`
+	RemovedCIDRs []string `json:"removedCIDRs"`
+	Trigger      Trigger  `json:"trigger"`
+}
+
+// Sink stores the audit records.
+type Sink interface {
+	Write(record *Record) error
+	Close() error
+}
+
+// NewRecord builds the record of the change of the generated policy in cluster from previous to current, previous
+// is nil when it's created, and current when it's deleted.
+func NewRecord(cluster, objID string, previous, current *v1net.NetworkPolicy, trigger Trigger) *Record {
+	record := &Record{
+		Time:             time.Now(),
+		Operation:        OperationUpdate,
+		OriginatingObjID: objID,
+		Cluster:          cluster,
+		Trigger:          trigger,
+	}
+
+	switch {
+	case previous == nil:
+		record.Operation = OperationCreate
+		record.Namespace, record.Name = current.Namespace, current.Name
+	case current == nil:
+		record.Operation = OperationDelete
+		record.Namespace, record.Name = previous.Namespace, previous.Name
+	default:
+		record.Namespace, record.Name = current.Namespace, current.Name
+	}
+
+	previousCIDRs, currentCIDRs := CIDRs(previous), CIDRs(current)
+	record.AddedCIDRs = difference(currentCIDRs, previousCIDRs)
+	record.RemovedCIDRs = difference(previousCIDRs, currentCIDRs)
+
+	return record
+}
+
+// CIDRs returns the sorted CIDRs of the ingress peers of np, which may be nil.
+func CIDRs(np *v1net.NetworkPolicy) []string {
+	if np == nil {
+		return []string{}
+	}
+
+	seen := map[string]bool{}
+	cidrs := []string{}
+
+	for i := range np.Spec.Ingress {
+		for _, peer := range np.Spec.Ingress[i].From {
+			if peer.IPBlock != nil && !seen[peer.IPBlock.CIDR] {
+				seen[peer.IPBlock.CIDR] = true
+				cidrs = append(cidrs, peer.IPBlock.CIDR)
+			}
+		}
+	}
+
+	sort.Strings(cidrs)
+
+	return cidrs
+}
+
+// difference returns the elements of a which aren't in b.
+func difference(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, element := range b {
+		inB[element] = true
+	}
+
+	diff := []string{}
+
+	for _, element := range a {
+		if !inB[element] {
+			diff = append(diff, element)
+		}
+	}
+
+	return diff
+}
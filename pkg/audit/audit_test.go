@@ -0,0 +1,169 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/audit"
+	v1net "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const objID = "cluster1:default/np1/uid1"
+
+var trigger = audit.Trigger{Event: "Added", ObjType: "pod", ObjID: "cluster2:default/pod1/uid2"}
+
+var _ = Describe("Audit records", func() {
+	It("Should record the created policy with all its CIDRs as added", func() {
+		record := audit.NewRecord("cluster1", objID, nil, generatedPolicy("10.0.0.2/32", "10.0.0.1/32"), trigger)
+		Expect(record.Operation).To(Equal(audit.OperationCreate))
+		Expect(record.Namespace).To(Equal("default"))
+		Expect(record.Name).To(Equal("coastguard-np1"))
+		Expect(record.AddedCIDRs).To(Equal([]string{"10.0.0.1/32", "10.0.0.2/32"}))
+		Expect(record.RemovedCIDRs).To(BeEmpty())
+		Expect(record.Trigger).To(Equal(trigger))
+	})
+
+	It("Should record the CIDRs added and removed by an update", func() {
+		record := audit.NewRecord("cluster1", objID, generatedPolicy("10.0.0.1/32", "10.0.0.2/32"),
+			generatedPolicy("10.0.0.2/32", "10.0.0.3/32"), trigger)
+		Expect(record.Operation).To(Equal(audit.OperationUpdate))
+		Expect(record.AddedCIDRs).To(Equal([]string{"10.0.0.3/32"}))
+		Expect(record.RemovedCIDRs).To(Equal([]string{"10.0.0.1/32"}))
+	})
+
+	It("Should record the deleted policy with all its CIDRs as removed", func() {
+		record := audit.NewRecord("cluster1", objID, generatedPolicy("10.0.0.1/32"), nil,
+			audit.Trigger{Event: audit.TriggerGarbageCollection})
+		Expect(record.Operation).To(Equal(audit.OperationDelete))
+		Expect(record.Name).To(Equal("coastguard-np1"))
+		Expect(record.AddedCIDRs).To(BeEmpty())
+		Expect(record.RemovedCIDRs).To(Equal([]string{"10.0.0.1/32"}))
+	})
+})
+
+var _ = Describe("File sink", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "audit.log")
+	})
+
+	readRecords := func(path string) []audit.Record {
+		data, err := os.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		records := []audit.Record{}
+
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			record := audit.Record{}
+			Expect(json.Unmarshal([]byte(line), &record)).To(Succeed())
+			records = append(records, record)
+		}
+
+		return records
+	}
+
+	It("Should append one JSON record per line", func() {
+		sink, err := audit.NewFileSink(path, 1024*1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sink.Write(audit.NewRecord("cluster1", objID, nil, generatedPolicy("10.0.0.1/32"), trigger))).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+
+		sink, err = audit.NewFileSink(path, 1024*1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sink.Write(audit.NewRecord("cluster1", objID, generatedPolicy("10.0.0.1/32"), nil, trigger))).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+
+		records := readRecords(path)
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Operation).To(Equal(audit.OperationCreate))
+		Expect(records[0].Trigger).To(Equal(trigger))
+		Expect(records[1].Operation).To(Equal(audit.OperationDelete))
+	})
+
+	It("Should rotate the file when it reaches its maximum size, keeping the given number of rotated files", func() {
+		// room for a single record per file
+		sink, err := audit.NewFileSink(path, 100, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, cidr := range []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32"} {
+			Expect(sink.Write(audit.NewRecord("cluster1", objID, nil, generatedPolicy(cidr), trigger))).To(Succeed())
+		}
+
+		Expect(sink.Close()).To(Succeed())
+		Expect(sink.Write(audit.NewRecord("cluster1", objID, nil, generatedPolicy("10.0.0.5/32"), trigger))).ToNot(Succeed())
+
+		Expect(readRecords(path)[0].AddedCIDRs).To(Equal([]string{"10.0.0.4/32"}))
+		Expect(readRecords(path + ".1")[0].AddedCIDRs).To(Equal([]string{"10.0.0.3/32"}))
+		Expect(readRecords(path + ".2")[0].AddedCIDRs).To(Equal([]string{"10.0.0.2/32"}))
+		Expect(path + ".3").ToNot(BeAnExistingFile())
+	})
+
+	It("Should keep writing to the file when the rotation fails, and rotate it later", func() {
+		sink, err := audit.NewFileSink(path, 100, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		DeferCleanup(sink.Close)
+
+		// a non-empty directory in the way of the rotated file
+		Expect(os.MkdirAll(filepath.Join(path+".1", "blocker"), 0o700)).To(Succeed())
+
+		Expect(sink.Write(audit.NewRecord("cluster1", objID, nil, generatedPolicy("10.0.0.1/32"), trigger))).To(Succeed())
+		Expect(sink.Write(audit.NewRecord("cluster1", objID, nil, generatedPolicy("10.0.0.2/32"), trigger))).ToNot(Succeed())
+		Expect(readRecords(path)).To(HaveLen(2))
+
+		Expect(os.RemoveAll(path + ".1")).To(Succeed())
+		Expect(sink.Write(audit.NewRecord("cluster1", objID, nil, generatedPolicy("10.0.0.3/32"), trigger))).To(Succeed())
+		Expect(readRecords(path)[0].AddedCIDRs).To(Equal([]string{"10.0.0.3/32"}))
+		Expect(readRecords(path + ".1")).To(HaveLen(2))
+	})
+
+	It("Should refuse invalid limits", func() {
+		_, err := audit.NewFileSink(path, 0, 1)
+		Expect(err).To(HaveOccurred())
+
+		_, err = audit.NewFileSink(path, 100, -1)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func generatedPolicy(cidrs ...string) *v1net.NetworkPolicy {
+	peers := []v1net.NetworkPolicyPeer{}
+	for _, cidr := range cidrs {
+		peers = append(peers, v1net.NetworkPolicyPeer{IPBlock: &v1net.IPBlock{CIDR: cidr}})
+	}
+
+	return &v1net.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "coastguard-np1"},
+		Spec:       v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{From: peers}}},
+	}
+}
+
+func TestAudit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: Audit suite")
+}
@@ -0,0 +1,169 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FileSink writes the records as JSON lines to a local file, which is rotated once it reaches its
+// maximum size, keeping the given number of rotated files as path.1 (the newest) to path.N.
+type FileSink struct {
+	mutex      sync.Mutex
+	file       *os.File
+	closed     bool
+	path       string
+	size       int64
+	maxSize    int64
+	maxBackups int
+}
+
+var _ Sink = &FileSink{}
+
+// NewFileSink opens the audit file at path, appending to it.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxSize <= 0 {
+		return nil, errors.Errorf("invalid maximum audit file size %d", maxSize)
+	}
+
+	if maxBackups < 0 {
+		return nil, errors.Errorf("invalid number of rotated audit files %d", maxBackups)
+	}
+
+	sink := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+
+	return sink, sink.open()
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "error opening the audit file %s", s.path)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrapf(err, "error checking the audit file %s", s.path)
+	}
+
+	s.file, s.size = file, info.Size()
+
+	return nil
+}
+
+// Write appends the record to the file, rotating it first when the record doesn't fit.
+func (s *FileSink) Write(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "error marshalling the audit record")
+	}
+
+	data = append(data, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return errors.Errorf("the audit file %s is closed", s.path)
+	}
+
+	// the file couldn't be reopened after a failed rotation, try again
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	var rotateErr error
+
+	if s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		rotateErr = s.rotate()
+		if s.file == nil {
+			return rotateErr
+		}
+	}
+
+	written, err := s.file.Write(data)
+	s.size += int64(written)
+
+	if err != nil {
+		return errors.Wrapf(err, "error writing to the audit file %s", s.path)
+	}
+
+	return rotateErr
+}
+
+// rotate shifts the rotated files, dropping the oldest, and starts a new file. Whether the files could be
+// shifted or not, the file at path is reopened, a failed rotation is retried once it's full again.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+
+	if err != nil {
+		err = errors.Wrapf(err, "error closing the audit file %s", s.path)
+	} else {
+		err = s.shift()
+	}
+
+	if openErr := s.open(); openErr != nil {
+		return openErr
+	}
+
+	return err
+}
+
+func (s *FileSink) shift() error {
+	if s.maxBackups == 0 {
+		return errors.Wrapf(os.Remove(s.path), "error removing the audit file %s", s.path)
+	}
+
+	for i := s.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(s.backup(i), s.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error rotating the audit file %s", s.backup(i))
+		}
+	}
+
+	return errors.Wrapf(os.Rename(s.path, s.backup(1)), "error rotating the audit file %s", s.path)
+}
+
+func (s *FileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return errors.Wrapf(err, "error closing the audit file %s", s.path)
+}
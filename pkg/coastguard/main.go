@@ -19,17 +19,64 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/audit"
+	"github.com/submariner-io/coastguard/pkg/config"
 	"github.com/submariner-io/coastguard/pkg/controller"
+	"github.com/submariner-io/coastguard/pkg/externalworkloads"
+	"github.com/submariner-io/coastguard/pkg/leader"
+	"github.com/submariner-io/coastguard/pkg/logging"
+	"github.com/submariner-io/coastguard/pkg/namespacemapping"
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/sharding"
+	"github.com/submariner-io/coastguard/pkg/sourceip"
+	"github.com/submariner-io/coastguard/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 )
 
 var (
-	kubeConfig string
-	masterURL  string
+	kubeConfig              string
+	masterURL               string
+	configFile              string
+	flagConfig              = config.Default()
+	activeConfig            atomic.Pointer[config.Config]
+	debugTokenFile          string
+	garbageCollection       controller.GarbageCollection
+	useFinalizers           bool
+	leaderElect             bool
+	leaderElectionNamespace string
+	shardingEnabled         bool
+	shardingNamespace       string
+	tracingExporter         string
+	tracingSampleRatio      float64
+	logFormat               string
+	explicitFlags           = map[string]string{}
+	auditFile               string
+	auditMaxSize            int64
+	auditMaxBackups         int
+	dryRun                  bool
+)
+
+const (
+	externalWorkloadsReloadPeriod = 30 * time.Second
+	configReloadPeriod            = 30 * time.Second
+	tracingShutdownTimeout        = 5 * time.Second
 )
 
 func init() {
@@ -37,19 +84,186 @@ func init() {
 		"Path to kubeconfig containing embedded authinfo.")
 	flag.StringVar(&masterURL, "master", "",
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&configFile, "config", "",
+		"Path to the configuration file, the flags set explicitly override its settings.")
+	bindConfigFlags(flag.CommandLine, flagConfig)
+	flag.StringVar(&debugTokenFile, "debug-token-file", "",
+		"Path to a file holding the bearer token required by /debug/state, which is disabled without it.")
+	flag.DurationVar(&garbageCollection.Period, "gc-period", 10*time.Minute,
+		"Period between the deletions of orphaned generated policies, 0 disables it.")
+	flag.BoolVar(&garbageCollection.DryRun, "gc-dry-run", false,
+		"Only log the orphaned generated policies which would be deleted.")
+	flag.IntVar(&garbageCollection.MaxDeletions, "gc-max-deletions", 50,
+		"Maximum number of orphaned generated policies deleted in one pass, passes finding more delete none.")
+	flag.BoolVar(&useFinalizers, "finalizers", false,
+		"Add a finalizer to the original policies with a generated counterpart, so it's always cleaned up.")
+	flag.BoolVar(&leaderElect, "leader-elect", false,
+		"Elect the replica distributing the generated policies with a Lease on the hub cluster, standbys keep warm caches.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the leader election Lease, defaults to the POD_NAMESPACE environment variable.")
+	flag.BoolVar(&shardingEnabled, "sharding", false,
+		"Shard the clusters whose policies are handled across the replicas, which announce themselves with Leases on the hub "+
+			"cluster. Exclusive with --leader-elect.")
+	flag.StringVar(&shardingNamespace, "sharding-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the shard membership Leases, defaults to the POD_NAMESPACE environment variable.")
+	flag.StringVar(&tracingExporter, "tracing-exporter", tracing.ExporterNone,
+		"Export OpenTelemetry traces of the event processing: otlp (configured with the OTEL_EXPORTER_OTLP_* environment "+
+			"variables) or stdout. Tracing is disabled by default.")
+	flag.Float64Var(&tracingSampleRatio, "tracing-sample-ratio", 1,
+		"Ratio of the event traces sampled, between 0 and 1.")
+	flag.StringVar(&logFormat, "log-format", logging.FormatText,
+		"Format of the logs: text, or json for one JSON object per line. -v=2 logs every event, -v=4 the matching of pods.")
+	flag.StringVar(&auditFile, "audit-file", "",
+		"Path to the file receiving one JSON audit record per generated policy change, the audit is disabled without it.")
+	flag.Int64Var(&auditMaxSize, "audit-max-size", 100*1024*1024,
+		"Size in bytes at which the audit file is rotated.")
+	flag.IntVar(&auditMaxBackups, "audit-max-backups", 5,
+		"Number of rotated audit files kept.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Compute the generated policies without writing anything to the clusters, the changes held back are logged, and "+
+			"served on /dryrun with the --debug-token-file token.")
+}
+
+// bindConfigFlags binds the flags of the settings which can also be set by the configuration file to c,
+// with the settings of c as defaults.
+func bindConfigFlags(fs *flag.FlagSet, c *config.Config) {
+	fs.StringVar(&c.HealthzAddress, "healthz-address", c.HealthzAddress,
+		"The address serving the health checks, metrics and debug endpoints.")
+	fs.IntVar(&c.EventChannelSize, "event-channel-size", c.EventChannelSize,
+		"Number of cluster events which can be queued for processing.")
+	fs.DurationVar(&c.ResyncPeriod.Duration, "resync-period", c.ResyncPeriod.Duration,
+		"Resync period of the cluster informers.")
+	fs.DurationVar(&c.PolicySyncPeriod.Duration, "policy-sync-period", c.PolicySyncPeriod.Duration,
+		"Period between the syncs of the generated policies.")
+	fs.Var(&float32Value{&c.ClientQPS}, "client-qps",
+		"Maximum queries per second to each cluster.")
+	fs.IntVar(&c.ClientBurst, "client-burst", c.ClientBurst,
+		"Maximum burst of queries to each cluster.")
+	fs.StringVar(&c.NamespaceMapping, "namespace-mapping", c.NamespaceMapping,
+		"The namespace/name of a ConfigMap declaring namespace equivalence classes across clusters.")
+	fs.StringVar(&c.ExternalWorkloadsFile, "external-workloads", c.ExternalWorkloadsFile,
+		"Path to a YAML file declaring external workloads which can be selected as ingress peers.")
+	fs.StringVar(&c.SourceIPResolvers, "source-ip-resolvers", c.SourceIPResolvers,
+		"Comma separated clusterID=resolver list selecting how the effective source IPs of each cluster pods are resolved, "+
+			"'*' as clusterID applies to all clusters. Resolvers: pod-ip (default), ovn-egressip, calico-egress-gateway.")
+	fs.StringVar(&c.SecondaryNetworks, "secondary-networks", c.SecondaryNetworks,
+		"Comma separated clusterID=network list of Multus secondary networks whose pod addresses are included as peers, "+
+			"'*' as clusterID applies to all clusters.")
+	fs.StringVar(&c.ClusterScopesFile, "cluster-scopes", c.ClusterScopesFile,
+		"Path to a YAML file limiting the namespaces and pods watched in each cluster, i.e. for namespace-scoped RBAC.")
+	fs.IntVar(&c.ReadyQuorum, "ready-quorum", c.ReadyQuorum,
+		"Number of synced clusters required to be ready, 0 requires all of them.")
+	fs.DurationVar(&c.LivenessTimeout.Duration, "liveness-timeout", c.LivenessTimeout.Duration,
+		"How long the processing loop may not go around before /livez fails.")
+	fs.BoolVar(&c.StatusAnnotations, "status-annotations", c.StatusAnnotations,
+		"Annotate the original policies with a summary of their cross-cluster effect.")
 }
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = f.Value.String()
+	})
+
+	if err := logging.SetFormat(logFormat, os.Stderr); err != nil {
+		fatal(err, "Invalid --log-format")
+	}
+
+	klog.V(logging.EventLevel).InfoS("Starting coastguard-network-policy-sync")
 
-	klog.V(2).Info("Starting coastguard-network-policy-sync")
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal(err, "Invalid configuration")
+	}
+
+	activeConfig.Store(cfg)
 
 	// set up signals so we handle the first shutdown signal gracefully
 	ctx := signals.SetupSignalHandler()
 	runStoppedCh := make(chan struct{})
 
+	shutdownTracing, err := tracing.Setup(tracingExporter, tracingSampleRatio, os.Stdout)
+	if err != nil {
+		fatal(err, "Error setting up tracing")
+	}
+
+	remotecluster.SetResyncPeriod(cfg.ResyncPeriod.Duration)
+
 	coastGuardController := controller.New()
+	coastGuardController.SetHealthzAddress(cfg.HealthzAddress)
+	coastGuardController.SetEventChannelSize(cfg.EventChannelSize)
+	coastGuardController.SetClientRateLimits(cfg.ClientQPS, cfg.ClientBurst)
+
+	// validated with the configuration
+	networks, _ := networkpolicy.ParseSecondaryNetworks(cfg.SecondaryNetworks)
+	coastGuardController.SetSecondaryNetworks(networks)
+
+	if cfg.ClusterScopesFile != "" {
+		scopes, err := remotecluster.LoadScopes(cfg.ClusterScopesFile)
+		if err != nil {
+			fatal(err, "Invalid --cluster-scopes")
+		}
+
+		coastGuardController.SetClusterScopes(scopes)
+	}
+
+	applyReloadableConfig(coastGuardController, cfg)
+	recordHubEvents(coastGuardController, ctx.Done())
+
+	if debugTokenFile != "" {
+		token, err := os.ReadFile(debugTokenFile)
+		if err != nil {
+			fatal(err, "Error reading --debug-token-file")
+		}
+
+		if strings.TrimSpace(string(token)) == "" {
+			fatal(nil, "The --debug-token-file is empty", "path", debugTokenFile)
+		}
+
+		coastGuardController.SetDebugToken(strings.TrimSpace(string(token)))
+	}
+	coastGuardController.SetGarbageCollection(garbageCollection)
+	coastGuardController.SetFinalizers(useFinalizers)
+	coastGuardController.SetDryRun(dryRun)
+
+	var auditSink audit.Sink
+
+	if auditFile != "" {
+		auditSink, err = audit.NewFileSink(auditFile, auditMaxSize, auditMaxBackups)
+		if err != nil {
+			fatal(err, "Error opening the --audit-file")
+		}
+
+		coastGuardController.SetAuditSink(auditSink)
+	}
+
+	if leaderElect && shardingEnabled {
+		fatal(nil, "--leader-elect and --sharding are exclusive, every shard member is the leader of its own clusters")
+	}
+
+	if leaderElect {
+		startLeaderElection(coastGuardController, ctx.Done())
+	}
+
+	if shardingEnabled {
+		startSharding(coastGuardController, ctx.Done())
+	}
+
+	if cfg.NamespaceMapping != "" {
+		watchNamespaceMapping(coastGuardController, ctx.Done())
+	}
+
+	if cfg.ExternalWorkloadsFile != "" {
+		externalworkloads.Watch(cfg.ExternalWorkloadsFile, externalWorkloadsReloadPeriod, coastGuardController.SetExternalWorkloads,
+			ctx.Done())
+	}
+
+	if configFile != "" {
+		config.Watch(configFile, configReloadPeriod, func() {
+			reloadConfig(coastGuardController)
+		}, ctx.Done())
+	}
 
 	go func() {
 		defer close(runStoppedCh)
@@ -57,5 +271,201 @@ func main() {
 	}()
 
 	<-runStoppedCh
-	klog.Info("All controllers stopped or exited. Stopping main loop")
+	klog.InfoS("All controllers stopped or exited. Stopping main loop")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		klog.ErrorS(err, "Error flushing the traces")
+	}
+
+	if auditSink != nil {
+		if err := auditSink.Close(); err != nil {
+			klog.ErrorS(err, "Error closing the audit file")
+		}
+	}
+
+	klog.Flush()
+}
+
+// fatal logs the error which prevents coastguard from running, and exits.
+func fatal(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorS(err, msg, keysAndValues...)
+	klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+}
+
+// loadConfig reads the configuration file, when there's one, into a new Config, overrides it with the flags
+// set explicitly, and validates the result.
+func loadConfig() (*config.Config, error) {
+	loaded := *flagConfig
+
+	if configFile != "" {
+		fromFile, err := config.Load(configFile)
+		if err != nil {
+			return nil, err
+		}
+
+		loaded = *fromFile
+
+		// the flags are bound to flagConfig, so they are applied over the file through a set bound to loaded
+		configFlags := flag.NewFlagSet("config", flag.ContinueOnError)
+		bindConfigFlags(configFlags, &loaded)
+
+		for name, value := range explicitFlags {
+			if configFlags.Lookup(name) == nil {
+				continue
+			}
+
+			if err := configFlags.Set(name, value); err != nil {
+				return nil, errors.Wrapf(err, "error applying --%s over the configuration file", name)
+			}
+		}
+	}
+
+	if err := loaded.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &loaded, nil
+}
+
+// currentConfig returns the configuration in effect, which is replaced as a whole on reloads and must not be
+// modified.
+func currentConfig() *config.Config {
+	return activeConfig.Load()
+}
+
+// reloadConfig reloads the modified configuration file, applying the settings which don't require a restart.
+// An invalid file is ignored, keeping the current settings.
+func reloadConfig(coastGuardController *controller.CoastguardController) {
+	cfg, err := loadConfig()
+	if err != nil {
+		klog.ErrorS(err, "Ignoring the invalid configuration file", "path", configFile)
+		return
+	}
+
+	previous := activeConfig.Swap(cfg)
+
+	if changes := config.StructuralChanges(previous, cfg); len(changes) > 0 {
+		klog.InfoS("Some changed settings only apply after a restart", "path", configFile, "settings", changes)
+	}
+
+	applyReloadableConfig(coastGuardController, cfg)
+	klog.InfoS("Reloaded the configuration file", "path", configFile)
+}
+
+// applyReloadableConfig applies the settings which don't require a restart.
+func applyReloadableConfig(coastGuardController *controller.CoastguardController, cfg *config.Config) {
+	// validated with the configuration
+	resolverKinds, _ := sourceip.ParseKinds(cfg.SourceIPResolvers)
+
+	coastGuardController.SetSourceIPResolvers(resolverKinds)
+	coastGuardController.SetPolicySyncPeriod(cfg.PolicySyncPeriod.Duration)
+	coastGuardController.SetReadyQuorum(cfg.ReadyQuorum)
+	coastGuardController.SetLivenessTimeout(cfg.LivenessTimeout.Duration)
+	coastGuardController.SetStatusAnnotations(cfg.StatusAnnotations)
+}
+
+// float32Value is a flag.Value setting a float32.
+type float32Value struct {
+	value *float32
+}
+
+func (f *float32Value) String() string {
+	if f.value == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(float64(*f.value), 'g', -1, 32)
+}
+
+func (f *float32Value) Set(s string) error {
+	value, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return errors.Wrapf(err, "invalid float32 %q", s)
+	}
+
+	*f.value = float32(value)
+
+	return nil
+}
+
+func watchNamespaceMapping(coastGuardController *controller.CoastguardController, stopCh <-chan struct{}) {
+	// validated with the configuration
+	namespace, name, _ := strings.Cut(currentConfig().NamespaceMapping, "/")
+
+	namespacemapping.Watch(hubClientSet(), namespace, name, coastGuardController.SetNamespaceMapping, stopCh)
+}
+
+func startLeaderElection(coastGuardController *controller.CoastguardController, stopCh <-chan struct{}) {
+	if leaderElectionNamespace == "" {
+		fatal(nil, "The leader election namespace must be set with --leader-election-namespace or POD_NAMESPACE")
+	}
+
+	// stand by until we are elected
+	coastGuardController.SetLeader(false)
+
+	go leader.Run(hubClientSet(), leader.DefaultConfig(leaderElectionNamespace, replicaIdentity()), coastGuardController.SetLeader,
+		stopCh)
+}
+
+func startSharding(coastGuardController *controller.CoastguardController, stopCh <-chan struct{}) {
+	if shardingNamespace == "" {
+		fatal(nil, "The sharding namespace must be set with --sharding-namespace or POD_NAMESPACE")
+	}
+
+	identity := replicaIdentity()
+	coastGuardController.EnableSharding(identity)
+
+	go sharding.NewMembership(hubClientSet(), shardingNamespace, identity).Run(coastGuardController.SetShardMembers, stopCh)
+}
+
+// recordHubEvents records the events about the clusters on the pod of this replica, when it's known.
+func recordHubEvents(coastGuardController *controller.CoastguardController, stopCh <-chan struct{}) {
+	podName, podNamespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if podName == "" || podNamespace == "" {
+		klog.InfoS("POD_NAME or POD_NAMESPACE isn't set, the events about the clusters won't be recorded")
+		return
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: hubClientSet().CoreV1().Events(podNamespace)})
+
+	go func() {
+		<-stopCh
+		broadcaster.Shutdown()
+	}()
+
+	coastGuardController.SetHubEventRecorder(
+		broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "coastguard"}),
+		&corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: podNamespace, Name: podName})
+}
+
+// replicaIdentity identifies this replica among the others, with the pod name.
+func replicaIdentity() string {
+	identity, err := os.Hostname()
+	if err != nil {
+		fatal(err, "Error getting the hostname as replica identity")
+	}
+
+	return identity
+}
+
+// hubClientSet creates a clientset for the hub cluster coastguard runs on.
+func hubClientSet() kubernetes.Interface {
+	restConfig, err := clientcmd.BuildConfigFromFlags(masterURL, kubeConfig)
+	if err != nil {
+		fatal(err, "Error building kubeconfig")
+	}
+
+	cfg := currentConfig()
+	restConfig.QPS, restConfig.Burst = cfg.ClientQPS, cfg.ClientBurst
+
+	clientSet, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		fatal(err, "Error creating clientset")
+	}
+
+	return clientSet
 }
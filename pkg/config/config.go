@@ -0,0 +1,209 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
+	"github.com/submariner-io/coastguard/pkg/sourceip"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// APIVersion and Kind identify the version of the configuration file format.
+const (
+	APIVersion = "coastguard.submariner.io/v1alpha1"
+	Kind       = "Configuration"
+)
+
+// Config holds the controller-wide settings, from the configuration file and the flags.
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// The structural settings only apply on startup, changing them requires a restart.
+
+	// HealthzAddress is the address serving the health checks, metrics and debug endpoints
+	HealthzAddress string `json:"healthzAddress,omitempty"`
+	// EventChannelSize is the number of cluster events which can be queued for processing
+	EventChannelSize int `json:"eventChannelSize,omitempty"`
+	// ResyncPeriod is the resync period of the cluster informers
+	ResyncPeriod metav1.Duration `json:"resyncPeriod,omitempty"`
+	// ClientQPS and ClientBurst limit the rate of the requests to each cluster
+	ClientQPS   float32 `json:"clientQPS,omitempty"`
+	ClientBurst int     `json:"clientBurst,omitempty"`
+	// ClusterScopesFile limits the namespaces and pods watched in each cluster
+	ClusterScopesFile string `json:"clusterScopesFile,omitempty"`
+	// NamespaceMapping is the namespace/name of the ConfigMap declaring namespace equivalence classes
+	NamespaceMapping string `json:"namespaceMapping,omitempty"`
+	// ExternalWorkloadsFile declares the external workloads which can be selected as ingress peers
+	ExternalWorkloadsFile string `json:"externalWorkloadsFile,omitempty"`
+	// SecondaryNetworks is the clusterID=network list of Multus networks whose addresses are peers
+	SecondaryNetworks string `json:"secondaryNetworks,omitempty"`
+
+	// The other settings are reloaded without a restart.
+
+	// PolicySyncPeriod is the period between the syncs of the generated policies
+	PolicySyncPeriod metav1.Duration `json:"policySyncPeriod,omitempty"`
+	// SourceIPResolvers is the clusterID=resolver list of source IP resolvers, for the clusters added later on
+	SourceIPResolvers string `json:"sourceIPResolvers,omitempty"`
+	// ReadyQuorum is the number of synced clusters required to be ready, 0 requires all of them
+	ReadyQuorum int `json:"readyQuorum,omitempty"`
+	// LivenessTimeout is how long the processing loop may not go around before it's considered wedged
+	LivenessTimeout metav1.Duration `json:"livenessTimeout,omitempty"`
+	// StatusAnnotations enables the annotation summarizing the cross-cluster effect of original policies
+	StatusAnnotations bool `json:"statusAnnotations,omitempty"`
+}
+
+// Default returns the settings used when neither the file nor the flags set them.
+func Default() *Config {
+	return &Config{
+		APIVersion:       APIVersion,
+		Kind:             Kind,
+		HealthzAddress:   ":8080",
+		EventChannelSize: 1000,
+		ResyncPeriod:     metav1.Duration{Duration: 24 * time.Hour},
+		ClientQPS:        5,
+		ClientBurst:      10,
+		PolicySyncPeriod: metav1.Duration{Duration: 5 * time.Second},
+		LivenessTimeout:  metav1.Duration{Duration: 3 * time.Minute},
+	}
+}
+
+// Load reads the configuration file, the settings it doesn't set keep their default.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading the configuration file %s", path)
+	}
+
+	config := Default()
+	config.APIVersion, config.Kind = "", ""
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, errors.Wrapf(err, "error parsing the configuration file %s", path)
+	}
+
+	if config.APIVersion != APIVersion || config.Kind != Kind {
+		return nil, errors.Errorf("the configuration file %s must be a %s %s, not %q %q", path, APIVersion, Kind,
+			config.APIVersion, config.Kind)
+	}
+
+	return config, nil
+}
+
+// Validate checks the settings, whether they come from the file or the flags.
+func (c *Config) Validate() error {
+	switch {
+	case c.HealthzAddress == "":
+		return errors.New("healthzAddress must be set")
+	case c.EventChannelSize <= 0:
+		return errors.Errorf("eventChannelSize must be positive, not %d", c.EventChannelSize)
+	case c.ResyncPeriod.Duration <= 0:
+		return errors.Errorf("resyncPeriod must be positive, not %s", c.ResyncPeriod.Duration)
+	case c.ClientQPS <= 0 || c.ClientBurst <= 0:
+		return errors.Errorf("clientQPS and clientBurst must be positive, not %v and %d", c.ClientQPS, c.ClientBurst)
+	case c.PolicySyncPeriod.Duration <= 0:
+		return errors.Errorf("policySyncPeriod must be positive, not %s", c.PolicySyncPeriod.Duration)
+	case c.LivenessTimeout.Duration <= 0:
+		return errors.Errorf("livenessTimeout must be positive, not %s", c.LivenessTimeout.Duration)
+	case c.ReadyQuorum < 0:
+		return errors.Errorf("readyQuorum can't be negative, not %d", c.ReadyQuorum)
+	}
+
+	if c.NamespaceMapping != "" {
+		if namespace, name, found := strings.Cut(c.NamespaceMapping, "/"); !found || namespace == "" || name == "" {
+			return errors.Errorf("namespaceMapping must be specified as namespace/name, not %q", c.NamespaceMapping)
+		}
+	}
+
+	if _, err := sourceip.ParseKinds(c.SourceIPResolvers); err != nil {
+		return errors.Wrap(err, "invalid sourceIPResolvers")
+	}
+
+	if _, err := networkpolicy.ParseSecondaryNetworks(c.SecondaryNetworks); err != nil {
+		return errors.Wrap(err, "invalid secondaryNetworks")
+	}
+
+	return nil
+}
+
+// StructuralChanges returns the names of the structural settings which differ between the configurations,
+// which only apply after a restart.
+func StructuralChanges(previous, current *Config) []string {
+	changes := []string{}
+
+	for name, changed := range map[string]bool{
+		"healthzAddress":        previous.HealthzAddress != current.HealthzAddress,
+		"eventChannelSize":      previous.EventChannelSize != current.EventChannelSize,
+		"resyncPeriod":          previous.ResyncPeriod != current.ResyncPeriod,
+		"clientQPS":             previous.ClientQPS != current.ClientQPS,
+		"clientBurst":           previous.ClientBurst != current.ClientBurst,
+		"clusterScopesFile":     previous.ClusterScopesFile != current.ClusterScopesFile,
+		"namespaceMapping":      previous.NamespaceMapping != current.NamespaceMapping,
+		"externalWorkloadsFile": previous.ExternalWorkloadsFile != current.ExternalWorkloadsFile,
+		"secondaryNetworks":     previous.SecondaryNetworks != current.SecondaryNetworks,
+	} {
+		if changed {
+			changes = append(changes, name)
+		}
+	}
+
+	sort.Strings(changes)
+
+	return changes
+}
+
+// Watch checks the configuration file every period, calling reload when it's modified.
+func Watch(path string, period time.Duration, reload func(), stopCh <-chan struct{}) {
+	modTime := func() time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			klog.ErrorS(err, "Unable to check the configuration file", "path", path)
+			return time.Time{}
+		}
+
+		return info.ModTime()
+	}
+
+	lastModTime := modTime()
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if current := modTime(); !current.IsZero() && !current.Equal(lastModTime) {
+					lastModTime = current
+					reload()
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/config"
+)
+
+const validConfig = `
+apiVersion: coastguard.submariner.io/v1alpha1
+kind: Configuration
+healthzAddress: ":9090"
+policySyncPeriod: 10s
+clientQPS: 20
+clientBurst: 40
+sourceIPResolvers: "*=ovn-egressip"
+`
+
+var _ = Describe("Configuration", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+	})
+
+	writeConfig := func(content string) {
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	}
+
+	When("loading a valid file", func() {
+		It("Should keep the defaults of the settings it doesn't set", func() {
+			writeConfig(validConfig)
+			cfg, err := config.Load(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Validate()).To(Succeed())
+			Expect(cfg.HealthzAddress).To(Equal(":9090"))
+			Expect(cfg.PolicySyncPeriod.Duration).To(Equal(10 * time.Second))
+			Expect(cfg.ClientQPS).To(BeNumerically("==", 20))
+			Expect(cfg.ClientBurst).To(Equal(40))
+			Expect(cfg.EventChannelSize).To(Equal(config.Default().EventChannelSize))
+			Expect(cfg.ResyncPeriod).To(Equal(config.Default().ResyncPeriod))
+		})
+	})
+
+	When("loading an invalid file", func() {
+		It("Should refuse another version", func() {
+			writeConfig("apiVersion: coastguard.submariner.io/v2\nkind: Configuration\n")
+			_, err := config.Load(path)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should refuse unknown settings", func() {
+			writeConfig(validConfig + "policySyncPerod: 1s\n")
+			_, err := config.Load(path)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("Should validate the settings", func() {
+		Expect(config.Default().Validate()).To(Succeed())
+
+		for _, invalidate := range []func(*config.Config){
+			func(c *config.Config) { c.HealthzAddress = "" },
+			func(c *config.Config) { c.EventChannelSize = 0 },
+			func(c *config.Config) { c.PolicySyncPeriod.Duration = 0 },
+			func(c *config.Config) { c.ClientQPS = -1 },
+			func(c *config.Config) { c.ReadyQuorum = -1 },
+			func(c *config.Config) { c.NamespaceMapping = "mapping" },
+			func(c *config.Config) { c.SourceIPResolvers = "cluster1=unknown" },
+		} {
+			cfg := config.Default()
+			invalidate(cfg)
+			Expect(cfg.Validate()).ToNot(Succeed())
+		}
+	})
+
+	It("Should list the structural settings which changed", func() {
+		previous, current := config.Default(), config.Default()
+		current.PolicySyncPeriod.Duration = time.Minute
+		current.StatusAnnotations = true
+		Expect(config.StructuralChanges(previous, current)).To(BeEmpty())
+
+		current.EventChannelSize = 10
+		current.HealthzAddress = ":9090"
+		Expect(config.StructuralChanges(previous, current)).To(Equal([]string{"eventChannelSize", "healthzAddress"}))
+	})
+
+	It("Should reload the modified file", func() {
+		writeConfig(validConfig)
+
+		reloaded := make(chan struct{}, 10)
+		stopCh := make(chan struct{})
+		DeferCleanup(func() { close(stopCh) })
+
+		config.Watch(path, 10*time.Millisecond, func() { reloaded <- struct{}{} }, stopCh)
+		Consistently(reloaded, 50*time.Millisecond).ShouldNot(Receive())
+
+		writeConfig(validConfig + "readyQuorum: 2\n")
+		Expect(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))).To(Succeed())
+		Eventually(reloaded).Should(Receive())
+	})
+})
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: Config suite")
+}
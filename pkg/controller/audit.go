@@ -0,0 +1,46 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/submariner-io/coastguard/pkg/audit"
+	v1net "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+)
+
+// SetAuditSink sets the sink receiving the audit records of the generated policy changes, it must be
+// called before Run. Nothing is audited without a sink.
+func (c *CoastguardController) SetAuditSink(sink audit.Sink) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.auditSink = sink
+}
+
+// auditChange records the change of the generated policy of objID in cluster from previous to current,
+// previous is nil when it was created, and current when it was deleted.
+func (c *CoastguardController) auditChange(cluster, objID string, previous, current *v1net.NetworkPolicy, trigger audit.Trigger) {
+	if c.auditSink == nil {
+		return
+	}
+
+	if err := c.auditSink.Write(audit.NewRecord(cluster, objID, previous, current, trigger)); err != nil {
+		klog.ErrorS(err, "Unable to write the audit record of the generated policy change", "cluster", cluster, "policy", objID)
+	}
+}
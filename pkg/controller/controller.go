@@ -19,16 +19,28 @@ limitations under the License.
 package controller
 
 import (
+	"net/http"
 	"sync"
+	"time"
 
+	"github.com/submariner-io/coastguard/pkg/audit"
+	"github.com/submariner-io/coastguard/pkg/externalworkloads"
 	"github.com/submariner-io/coastguard/pkg/healthz"
+	"github.com/submariner-io/coastguard/pkg/metrics"
+	"github.com/submariner-io/coastguard/pkg/namespacemapping"
 	"github.com/submariner-io/coastguard/pkg/networkpolicy"
 	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/sharding"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
-// arbitrary event channel size.
-const eventChannelSize = 1000
+// Defaults of the settings which can be configured before Run.
+const (
+	defaultEventChannelSize = 1000
+	defaultHealthzAddress   = ":8080"
+)
 
 type CoastguardController struct {
 	// remoteClusters is a map of remote clusters, which have been discovered
@@ -36,11 +48,21 @@ type CoastguardController struct {
 	// syncedClusters is a map of remote clusters, which have been discovered,
 	// and also our local cache is in sync with them
 	syncedClusters map[string]*remotecluster.RemoteCluster
+	// permissionChecks counts the permission checks started for each cluster
+	permissionChecks map[string]uint64
 
 	// clusterEvents is the channel to receive events from all the
 	// existing remote clusters
 	clusterEvents chan *remotecluster.Event
 
+	// namespaceMappings is the channel used to hand new namespace mappings
+	// over to the processing loop
+	namespaceMappings chan *namespacemapping.Mapping
+
+	// externalWorkloads is the channel used to hand new external workload
+	// registries over to the processing loop
+	externalWorkloads chan *externalworkloads.Registry
+
 	// processingMutex is used to avoid synchronization issues when handling
 	// objects inside the controller, it's a generalistic lock, although
 	// later in time we can come up with a more granular implementation.
@@ -49,30 +71,147 @@ type CoastguardController struct {
 	remoteNetworkPolicies    map[string]*networkpolicy.RemoteNetworkPolicy
 	remoteGenNetworkPolicies map[string]*remoteGeneratedNetworkPolicy
 	remotePods               map[string]*networkpolicy.RemotePod
+	remoteEndpointSlices     map[string]*networkpolicy.RemoteEndpointSlice
+
+	// propagationStart is when the generated policies waiting to be written were changed by a pod, by ObjID
+	propagationStart map[string]time.Time
+
+	// namespaceMapping declares which namespaces are equivalent across clusters
+	namespaceMapping *namespacemapping.Mapping
+
+	// externalCluster is the virtual cluster external workloads belong to, and
+	// externalPods are those workloads represented as pods, indexed by ObjID
+	externalCluster *remotecluster.RemoteCluster
+	externalPods    map[string]*v1.Pod
+
+	// sourceIPResolverKinds are the kinds of source IP resolver to use for each cluster
+	sourceIPResolverKinds map[string]string
+
+	// secondaryNetworks are the Multus networks whose addresses are included as peers for each cluster
+	secondaryNetworks map[string][]string
+
+	// clusterScopes limit the namespaces and pods watched in each cluster
+	clusterScopes map[string]remotecluster.Scope
+
+	// hubEventRecorder records the events about the clusters on hubEventObject, in the hub cluster
+	hubEventRecorder record.EventRecorder
+	hubEventObject   *v1.ObjectReference
+
+	// garbageCollection configures the deletion of orphaned generated policies, garbageCollecting is true while
+	// a pass lists the generated policies, which are handed over to the processing loop on generatedPolicyLists
+	garbageCollection    GarbageCollection
+	garbageCollecting    bool
+	generatedPolicyLists chan *generatedPolicyList
+
+	// useFinalizers enables the finalizer on original policies with a generated counterpart
+	useFinalizers bool
+
+	// auditSink receives the audit records of the generated policy changes, nil disables the audit
+	auditSink audit.Sink
+
+	// statusAnnotations enables the annotation summarizing the cross-cluster effect of original policies
+	statusAnnotations bool
+
+	// leading is true when this replica writes to the clusters, standby replicas only keep
+	// their caches warm
+	leading bool
+
+	// stateRequests is the channel used to ask the processing loop for snapshots of its caches
+	stateRequests chan *stateRequest
+
+	// debugToken authenticates the requests to /debug/state, which is disabled when it's empty
+	debugToken string
+
+	// shardMembers is the channel used to hand the live shard members over to the processing loop
+	shardMembers chan []string
+
+	// shardIdentity is the member name of this replica, and shardRing assigns the clusters to the
+	// members, this replica only handles the policies of the clusters it owns. A nil ring means no
+	// sharding, owning every cluster.
+	shardIdentity string
+	shardRing     *sharding.Ring
+
+	// readyQuorum is the number of synced clusters required to be ready, 0 requires all of them
+	readyQuorum int
+
+	// heartbeat is when the processing loop last went around, in Unix nanoseconds, and the loop is
+	// considered wedged when it's older than livenessTimeout
+	heartbeat       int64
+	livenessTimeout int64
+
+	// policySyncPeriods is the channel used to hand a new policy sync period over to the processing loop
+	policySyncPeriods chan time.Duration
+
+	// healthzAddress is the address serving the health checks, metrics and debug endpoints
+	healthzAddress string
+
+	// dryRun computes the generated policies without writing anything, plannedChanges are the writes held
+	// back by the last sync, and previousPlan those of the sync before
+	dryRun         bool
+	plannedChanges map[string]*PlannedChange
+	previousPlan   map[string]*PlannedChange
+
+	// planRequests is the channel used to ask the processing loop for the planned changes
+	planRequests chan *planRequest
+
+	// clientQPS and clientBurst limit the rate of the requests to the clusters added, 0 keeps the
+	// client-go defaults
+	clientQPS   float32
+	clientBurst int
 }
 
 func New() *CoastguardController {
 	return &CoastguardController{
 		remoteClusters:           make(map[string]*remotecluster.RemoteCluster),
 		syncedClusters:           make(map[string]*remotecluster.RemoteCluster),
+		permissionChecks:         make(map[string]uint64),
 		processingMutex:          &sync.Mutex{},
-		clusterEvents:            make(chan *remotecluster.Event, eventChannelSize),
+		clusterEvents:            make(chan *remotecluster.Event, defaultEventChannelSize),
+		namespaceMappings:        make(chan *namespacemapping.Mapping, 1),
+		externalWorkloads:        make(chan *externalworkloads.Registry, 1),
+		shardMembers:             make(chan []string, 1),
+		policySyncPeriods:        make(chan time.Duration, 1),
+		stateRequests:            make(chan *stateRequest),
+		planRequests:             make(chan *planRequest),
+		generatedPolicyLists:     make(chan *generatedPolicyList, 1),
+		plannedChanges:           make(map[string]*PlannedChange),
 		remoteNetworkPolicies:    make(map[string]*networkpolicy.RemoteNetworkPolicy),
 		remoteGenNetworkPolicies: make(map[string]*remoteGeneratedNetworkPolicy),
 		remotePods:               make(map[string]*networkpolicy.RemotePod),
+		remoteEndpointSlices:     make(map[string]*networkpolicy.RemoteEndpointSlice),
+		externalPods:             make(map[string]*v1.Pod),
+		propagationStart:         make(map[string]time.Time),
+		leading:                  true,
+		heartbeat:                time.Now().UnixNano(),
+		livenessTimeout:          int64(defaultLivenessTimeout),
+		healthzAddress:           defaultHealthzAddress,
 	}
 }
 
 func (c *CoastguardController) Run(stopCh <-chan struct{}) {
 	go c.processLoop(stopCh)
 
-	healthzServer := healthz.New(":8080")
+	healthzServer := healthz.New(c.healthzAddress)
+	healthzServer.SetReadinessCheck(c.readiness)
+	healthzServer.SetLivenessCheck(c.liveness)
+	healthzServer.SetDetails(c.healthDetails)
+	healthzServer.Handle("/clusters", http.HandlerFunc(c.serveClusterStatuses))
+	healthzServer.Handle("/metrics", metrics.Handler())
+
+	if c.debugToken != "" {
+		healthzServer.Handle("/debug/state", c.authorized(c.serveState))
+
+		if c.dryRun {
+			healthzServer.Handle("/dryrun", c.authorized(c.servePlan))
+		}
+	}
+
 	go healthzServer.Run(stopCh)
 
 	// we stop here until the stopCh channel is closed
 	<-stopCh
 
-	klog.Info("Stopping remote cluster informers")
+	klog.InfoS("Stopping remote cluster informers")
 
 	// ensure other go routines have stopped too
 	for _, remoteCluster := range c.remoteClusters {
@@ -88,3 +227,103 @@ func (c *CoastguardController) AllClustersSynced() bool {
 
 	return len(c.syncedClusters) == len(c.remoteClusters)
 }
+
+// SetEventChannelSize sets the number of cluster events which can be queued for processing, it must be
+// called before Run and before any cluster is added.
+func (c *CoastguardController) SetEventChannelSize(size int) {
+	c.clusterEvents = make(chan *remotecluster.Event, size)
+}
+
+// SetHealthzAddress sets the address serving the health checks, metrics and debug endpoints, it must be
+// called before Run.
+func (c *CoastguardController) SetHealthzAddress(address string) {
+	c.healthzAddress = address
+}
+
+// SetClientRateLimits limits the rate of the requests to the clusters added later on.
+func (c *CoastguardController) SetClientRateLimits(qps float32, burst int) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.clientQPS, c.clientBurst = qps, burst
+}
+
+// SetLeader switches this replica between leading and standing by, see leader.Run.
+func (c *CoastguardController) SetLeader(leading bool) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.leading = leading
+}
+
+func (c *CoastguardController) IsLeader() bool {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	return c.leading
+}
+
+// SetSourceIPResolvers configures the kind of source IP resolver used for the clusters discovered
+// from now on, see sourceip.ParseKinds.
+func (c *CoastguardController) SetSourceIPResolvers(kinds map[string]string) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.sourceIPResolverKinds = kinds
+}
+
+// SetSecondaryNetworks configures the Multus networks whose pod addresses are included as peers,
+// for the clusters discovered from now on, see networkpolicy.ParseSecondaryNetworks.
+func (c *CoastguardController) SetSecondaryNetworks(networks map[string][]string) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.secondaryNetworks = networks
+}
+
+// SetClusterScopes configures the namespaces and pods watched in the clusters discovered from now on,
+// see remotecluster.LoadScopes.
+func (c *CoastguardController) SetClusterScopes(scopes map[string]remotecluster.Scope) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.clusterScopes = scopes
+}
+
+// SetFinalizers enables or disables adding our finalizer to the original policies with a generated
+// counterpart, it must be called before Run. The finalizers already set are removed either way.
+func (c *CoastguardController) SetFinalizers(enabled bool) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.useFinalizers = enabled
+}
+
+// SetNamespaceMapping hands a new namespace mapping over to the processing loop,
+// when several mappings are set in a row only the latest one is kept.
+func (c *CoastguardController) SetNamespaceMapping(mapping *namespacemapping.Mapping) {
+	sendLatest(c.namespaceMappings, mapping)
+}
+
+// SetExternalWorkloads hands a new external workload registry over to the processing loop,
+// when several registries are set in a row only the latest one is kept.
+func (c *CoastguardController) SetExternalWorkloads(registry *externalworkloads.Registry) {
+	sendLatest(c.externalWorkloads, registry)
+}
+
+// sendLatest sends value over a channel with a buffer of one, replacing any pending value
+// which was not received yet.
+func sendLatest[T any](ch chan T, value T) {
+	for {
+		select {
+		case ch <- value:
+			return
+		default:
+			// drop the pending value which was not received yet
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}
+}
@@ -19,13 +19,36 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/submariner-io/coastguard/pkg/audit"
+	"github.com/submariner-io/coastguard/pkg/externalworkloads"
+	"github.com/submariner-io/coastguard/pkg/metrics"
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
 	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	v1 "k8s.io/api/core/v1"
+	v1net "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
@@ -57,6 +80,874 @@ var _ = Describe("Coastguard Controller", func() {
 			cgController.onClusterFinishedSyncing(remoteCluster)
 			Expect(cgController.syncedClusters).Should(HaveKey(clusterID1))
 		})
+
+		It("Should stop and forget a removed cluster", func() {
+			cgController.addCluster(clusterID1, fake.NewSimpleClientset())
+			remoteCluster := cgController.remoteClusters[clusterID1]
+			cgController.OnRemove(clusterID1)
+			Expect(cgController.remoteClusters).ShouldNot(HaveKey(clusterID1))
+			Expect(remoteCluster.Stopped()).To(BeTrue())
+
+			var event *remotecluster.Event
+			Eventually(cgController.clusterEvents).Should(Receive(&event, HaveField("ObjType", remotecluster.Cluster)))
+		})
+	})
+
+	Context("Cluster scopes", func() {
+		It("Should show the scope of each cluster in the cluster statuses", func() {
+			cgController.SetClusterScopes(map[string]remotecluster.Scope{
+				clusterID1: {Namespaces: []string{"payments"}},
+			})
+			cgController.addCluster(clusterID1, fake.NewSimpleClientset())
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+			DeferCleanup(func() {
+				cgController.remoteClusters[clusterID1].Stop()
+				cgController.remoteClusters[clusterID2].Stop()
+			})
+
+			statuses := cgController.ClusterStatuses()
+			Expect(statuses).To(HaveLen(2))
+			Expect(statuses[0]).To(And(HaveField("ClusterID", clusterID1), HaveField("Scope", "namespaces payments"),
+				HaveField("ScopeLimited", true), HaveField("WatchedNamespaces", []string{"payments"})))
+			Expect(statuses[1]).To(And(HaveField("ClusterID", clusterID2), HaveField("Scope", "all namespaces"),
+				HaveField("ScopeLimited", false), HaveField("WatchedNamespaces", BeEmpty())))
+		})
+	})
+
+	Context("Permission checks", func() {
+		var (
+			recorder  *record.FakeRecorder
+			clientSet *fake.Clientset
+			allowed   bool
+		)
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+			cgController.SetHubEventRecorder(recorder, &v1.ObjectReference{Kind: "Pod", Namespace: "coastguard", Name: "coastguard-0"})
+
+			allowed = false
+			clientSet = fake.NewSimpleClientset()
+			clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				review.Status.Allowed = allowed || review.Spec.ResourceAttributes.Resource != "pods"
+
+				return true, review, nil
+			})
+
+			cgController.addCluster(clusterID1, fake.NewSimpleClientset())
+			DeferCleanup(cgController.remoteClusters[clusterID1].Stop)
+		})
+
+		It("Should mark a cluster missing permissions as degraded until they are granted", func() {
+			cgController.checkPermissions(cgController.remoteClusters[clusterID1], clientSet)
+
+			statuses := cgController.ClusterStatuses()
+			Expect(statuses[0].Degraded).To(BeTrue())
+			Expect(statuses[0].MissingPermissions).To(ConsistOf(
+				remotecluster.Permission{Verb: "list", Resource: "pods"}, remotecluster.Permission{Verb: "watch", Resource: "pods"}))
+			Expect(recorder.Events).To(Receive(ContainSubstring("%s is missing permissions: list pods, watch pods", clusterID1)))
+
+			allowed = true
+			cgController.checkPermissions(cgController.remoteClusters[clusterID1], clientSet)
+			Expect(cgController.ClusterStatuses()[0].Degraded).To(BeFalse())
+			Expect(recorder.Events).To(Receive(ContainSubstring(ReasonClusterPermissions)))
+		})
+	})
+
+	Context("External workloads", func() {
+		registry := &externalworkloads.Registry{
+			ClusterID: externalworkloads.DefaultClusterID,
+			Workloads: []externalworkloads.Workload{{Name: "vm-1", Namespace: "payments", IPs: []string{"10.0.0.5"}}},
+		}
+
+		It("Should track external workloads as pods of the virtual cluster", func() {
+			cgController.applyExternalWorkloads(registry)
+			Expect(cgController.remotePods).To(HaveLen(1))
+
+			for _, remotePod := range cgController.remotePods {
+				Expect(remotePod.ObjID).To(HavePrefix(externalworkloads.DefaultClusterID + ":payments/vm-1"))
+			}
+		})
+
+		It("Should update changed workloads and remove the ones no longer declared", func() {
+			cgController.applyExternalWorkloads(registry)
+
+			updated := &externalworkloads.Registry{ClusterID: registry.ClusterID, Workloads: []externalworkloads.Workload{
+				{Name: "vm-1", Namespace: "payments", IPs: []string{"10.0.0.6"}},
+			}}
+			cgController.applyExternalWorkloads(updated)
+			Expect(cgController.remotePods).To(HaveLen(1))
+
+			for _, remotePod := range cgController.remotePods {
+				Expect(remotePod.Pod.Status.PodIP).To(Equal("10.0.0.6"))
+			}
+
+			cgController.applyExternalWorkloads(nil)
+			Expect(cgController.remotePods).To(BeEmpty())
+		})
+	})
+
+	Context("Garbage collection", func() {
+		var clientSet *fake.Clientset
+
+		BeforeEach(func() {
+			clientSet = fake.NewSimpleClientset(
+				newGeneratedPolicy("coastguard-uid1", "uid1", clusterID1+":default/np1/uid1"),
+				newGeneratedPolicy("coastguard-uid2", "uid2", clusterID1+":default/np2/uid2"),
+				newGeneratedPolicy("coastguard-uid3", "uid3", clusterID1+":default/np3/uid3"),
+			)
+			cgController.addCluster(clusterID1, clientSet)
+
+			original := &v1net.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"}}
+			objID := clusterID1 + ":default/np1/uid1"
+			cgController.remoteNetworkPolicies[objID] = networkpolicy.NewRemoteNetworkPolicy(original,
+				cgController.remoteClusters[clusterID1], objID, nil, nil, nil, nil)
+		})
+
+		generatedPolicies := func() []string {
+			nps, err := clientSet.NetworkingV1().NetworkPolicies("default").List(context.TODO(), metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			names := []string{}
+			for i := range nps.Items {
+				names = append(names, nps.Items[i].Name)
+			}
+
+			return names
+		}
+
+		collect := func(gc GarbageCollection) {
+			cgController.deleteOrphanedPolicies(gc, listGeneratedPolicies(cgController.clusters()))
+		}
+
+		It("Should list the generated policies in the background, and hand them over to the processing loop", func() {
+			cgController.SetGarbageCollection(GarbageCollection{MaxDeletions: 10})
+			cgController.onClusterFinishedSyncing(cgController.remoteClusters[clusterID1])
+
+			cgController.collectGarbage()
+			Expect(cgController.garbageCollecting).To(BeTrue())
+
+			By("Skipping the passes while the previous one is still running")
+			cgController.collectGarbage()
+
+			var list *generatedPolicyList
+			Eventually(cgController.generatedPolicyLists).Should(Receive(&list))
+			Expect(list.policies).To(HaveLen(3))
+			Consistently(cgController.generatedPolicyLists).ShouldNot(Receive())
+		})
+
+		It("Should delete the generated policies whose original policy doesn't exist", func() {
+			collect(GarbageCollection{MaxDeletions: 10})
+			Expect(generatedPolicies()).To(ConsistOf("coastguard-uid1"))
+		})
+
+		It("Should delete the orphaned policies generated by former versions", func() {
+			legacy := newGeneratedPolicy("coastguard-uid4", "uid4", clusterID1+":default/np4/uid4")
+			delete(legacy.Labels, remotecluster.ManagedByLabel)
+			legacy.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "coastguard-controller"}}
+			_, err := clientSet.NetworkingV1().NetworkPolicies("default").Create(context.TODO(), legacy, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			collect(GarbageCollection{MaxDeletions: 10})
+			Expect(generatedPolicies()).To(ConsistOf("coastguard-uid1"))
+		})
+
+		It("Should only list the watched namespaces of a limited cluster", func() {
+			other := newGeneratedPolicy("coastguard-uid5", "uid5", clusterID2+":payments/np5/uid5")
+			other.Namespace = "payments"
+			scopedClientSet := fake.NewSimpleClientset(newGeneratedPolicy("coastguard-uid4", "uid4", clusterID2+":default/np4/uid4"),
+				other)
+			cgController.SetClusterScopes(map[string]remotecluster.Scope{clusterID2: {Namespaces: []string{"default"}}})
+			cgController.addCluster(clusterID2, scopedClientSet)
+
+			collect(GarbageCollection{MaxDeletions: 10})
+
+			for _, action := range scopedClientSet.Actions() {
+				if action.GetVerb() == "list" && action.GetResource().Resource == "networkpolicies" {
+					Expect(action.GetNamespace()).To(Equal("default"))
+				}
+			}
+
+			_, err := scopedClientSet.NetworkingV1().NetworkPolicies("payments").Get(context.TODO(), "coastguard-uid5", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should not delete anything in dry-run mode", func() {
+			collect(GarbageCollection{MaxDeletions: 10, DryRun: true})
+			Expect(generatedPolicies()).To(HaveLen(3))
+		})
+
+		It("Should not delete anything when there are more orphans than allowed", func() {
+			collect(GarbageCollection{MaxDeletions: 1})
+			Expect(generatedPolicies()).To(HaveLen(3))
+		})
+	})
+
+	Context("Finalizers on original policies", func() {
+		const objID = clusterID1 + ":default/np1/uid1"
+
+		var (
+			clientSet *fake.Clientset
+			original  *v1net.NetworkPolicy
+		)
+
+		BeforeEach(func() {
+			original = &v1net.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"},
+				Spec: v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{
+					From: []v1net.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+				}}},
+			}
+		})
+
+		JustBeforeEach(func() {
+			clientSet = fake.NewSimpleClientset(original)
+			cgController.addCluster(clusterID1, clientSet)
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod-uid1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.1.0.1"},
+			}
+			podObjID := remotecluster.ObjID(clusterID2, pod.Namespace, pod.Name, pod.UID)
+			remotePods := map[string]*networkpolicy.RemotePod{
+				podObjID: networkpolicy.NewRemotePod(pod, cgController.remoteClusters[clusterID2], podObjID),
+			}
+
+			cgController.remoteNetworkPolicies[objID] = networkpolicy.NewRemoteNetworkPolicy(original,
+				cgController.remoteClusters[clusterID1], objID, remotePods, nil, nil, nil)
+		})
+
+		getOriginal := func() *v1net.NetworkPolicy {
+			np, err := clientSet.NetworkingV1().NetworkPolicies("default").Get(context.TODO(), "np1", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			return np
+		}
+
+		It("Should add the finalizer before distributing the generated policy when enabled", func() {
+			cgController.SetFinalizers(true)
+			cgController.processPoliciesNeedingDistribution()
+			Expect(getOriginal().Finalizers).To(ContainElement(remotecluster.Finalizer))
+		})
+
+		It("Should add the finalizer when the generated policy is already in sync", func() {
+			rnp := cgController.remoteNetworkPolicies[objID]
+			cgController.remoteGenNetworkPolicies[objID] = &remoteGeneratedNetworkPolicy{
+				cluster: cgController.remoteClusters[clusterID1], np: rnp.GeneratedPolicy.DeepCopy(),
+			}
+
+			cgController.SetFinalizers(true)
+			cgController.processPoliciesNeedingDistribution()
+			Expect(getOriginal().Finalizers).To(ContainElement(remotecluster.Finalizer))
+
+			for _, action := range clientSet.Actions() {
+				Expect(action.GetResource().Resource == "networkpolicies" && action.GetVerb() == "patch" &&
+					action.GetSubresource() == "" && action.(k8stesting.PatchAction).GetName() == rnp.GeneratedPolicy.Name).
+					To(BeFalse(), "the generated policy in sync was written")
+			}
+		})
+
+		It("Should not write anything while standing by", func() {
+			cgController.SetFinalizers(true)
+			cgController.SetLeader(false)
+			cgController.onClusterFinishedSyncing(cgController.remoteClusters[clusterID1])
+			cgController.onClusterFinishedSyncing(cgController.remoteClusters[clusterID2])
+			cgController.syncGeneratedPolicies()
+			Expect(getOriginal().Finalizers).To(BeEmpty())
+			Expect(cgController.healthDetails()).To(HaveField("Role", RoleStandby))
+
+			cgController.SetLeader(true)
+			cgController.syncGeneratedPolicies()
+			Expect(getOriginal().Finalizers).To(ContainElement(remotecluster.Finalizer))
+			Expect(cgController.healthDetails()).To(HaveField("Role", RoleLeader))
+		})
+
+		It("Should not add the finalizer when disabled", func() {
+			cgController.processPoliciesNeedingDistribution()
+			Expect(getOriginal().Finalizers).To(BeEmpty())
+		})
+
+		When("the original policy is being deleted", func() {
+			BeforeEach(func() {
+				now := metav1.Now()
+				original.DeletionTimestamp = &now
+				original.Finalizers = []string{remotecluster.Finalizer}
+			})
+
+			It("Should not generate anything for it", func() {
+				Expect(cgController.remoteNetworkPolicies[objID].GeneratedPolicy).To(BeNil())
+			})
+
+			It("Should delete the generated policy before removing the finalizer", func() {
+				generated := newGeneratedPolicy("coastguard-uid1", "uid1", objID)
+				Expect(clientSet.Tracker().Add(generated)).To(Succeed())
+				cgController.remoteGenNetworkPolicies[objID] = &remoteGeneratedNetworkPolicy{
+					cluster: cgController.remoteClusters[clusterID1], np: generated,
+				}
+
+				cgController.processPoliciesNeedingDelete()
+				Expect(getOriginal().Finalizers).To(ContainElement(remotecluster.Finalizer))
+				_, err := clientSet.NetworkingV1().NetworkPolicies("default").Get(context.TODO(), generated.Name, metav1.GetOptions{})
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+				delete(cgController.remoteGenNetworkPolicies, objID)
+				cgController.processPoliciesNeedingDelete()
+				Expect(getOriginal().Finalizers).To(BeEmpty())
+			})
+
+			It("Should remove the finalizer when its cluster is unregistered", func() {
+				cgController.removedCluster(cgController.remoteClusters[clusterID1])
+				Expect(getOriginal().Finalizers).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("Sharding", func() {
+		var remoteCluster *remotecluster.RemoteCluster
+
+		original := &v1net.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"}}
+
+		BeforeEach(func() {
+			cgController.addCluster(clusterID1, fake.NewSimpleClientset(original))
+			remoteCluster = cgController.remoteClusters[clusterID1]
+			Eventually(remoteCluster.HasSynced).Should(BeTrue())
+			cgController.EnableSharding("replica-1")
+		})
+
+		It("Should ignore the policies of clusters owned by other replicas", func() {
+			cgController.processEvent(remoteCluster.NewAddEvent(original))
+			Expect(cgController.remoteNetworkPolicies).To(BeEmpty())
+		})
+
+		It("Should rebalance the policies on membership changes", func() {
+			cgController.applyShardMembers([]string{"replica-1"})
+			Expect(cgController.remoteNetworkPolicies).To(HaveLen(1))
+
+			cgController.applyShardMembers([]string{"replica-2"})
+			Expect(cgController.remoteNetworkPolicies).To(BeEmpty())
+		})
+	})
+
+	Context("Health checks", func() {
+		BeforeEach(func() {
+			for _, clusterID := range []string{clusterID1, clusterID2} {
+				cgController.addCluster(clusterID, fake.NewSimpleClientset())
+				DeferCleanup(cgController.remoteClusters[clusterID].Stop)
+			}
+
+			// the clusters sync on their own, start over once they did
+			Eventually(func() int {
+				cgController.processingMutex.Lock()
+				defer cgController.processingMutex.Unlock()
+
+				return len(cgController.syncedClusters)
+			}).Should(Equal(2))
+			cgController.processingMutex.Lock()
+			cgController.syncedClusters = map[string]*remotecluster.RemoteCluster{}
+			cgController.processingMutex.Unlock()
+		})
+
+		It("Should be ready once all the clusters are synced", func() {
+			Expect(cgController.readiness()).To(MatchError("0 of 2 clusters synced, 2 required"))
+
+			cgController.onClusterFinishedSyncing(cgController.remoteClusters[clusterID1])
+			Expect(cgController.readiness()).To(HaveOccurred())
+
+			cgController.onClusterFinishedSyncing(cgController.remoteClusters[clusterID2])
+			Expect(cgController.readiness()).To(Succeed())
+		})
+
+		It("Should be ready while standing by", func() {
+			cgController.SetLeader(false)
+			Expect(cgController.readiness()).To(Succeed())
+		})
+
+		It("Should be ready once a quorum of clusters is synced", func() {
+			cgController.SetReadyQuorum(1)
+			Expect(cgController.readiness()).To(HaveOccurred())
+
+			cgController.onClusterFinishedSyncing(cgController.remoteClusters[clusterID1])
+			Expect(cgController.readiness()).To(Succeed())
+		})
+
+		It("Should not be alive once the processing loop stops going around", func() {
+			cgController.SetLivenessTimeout(50 * time.Millisecond)
+			cgController.beat()
+			Expect(cgController.liveness()).To(Succeed())
+			Eventually(cgController.liveness).Should(MatchError(ContainSubstring("hasn't gone around")))
+		})
+	})
+
+	Context("Debug state", func() {
+		const objID = clusterID1 + ":default/np1/uid1"
+
+		BeforeEach(func() {
+			cgController.addCluster(clusterID1, fake.NewSimpleClientset())
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+			rc1, rc2 := cgController.remoteClusters[clusterID1], cgController.remoteClusters[clusterID2]
+			DeferCleanup(rc1.Stop)
+			DeferCleanup(rc2.Stop)
+
+			cgController.processEvent(rc1.NewAddEvent(&v1net.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"},
+				Spec: v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{
+					From: []v1net.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+				}}},
+			}))
+			cgController.processEvent(rc2.NewAddEvent(&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod-uid1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.1.0.1", PodIPs: []v1.PodIP{{IP: "10.1.0.1"}}},
+			}))
+		})
+
+		It("Should show the selected pods and the wanted policy", func() {
+			state := cgController.snapshotState(StateFilter{})
+			Expect(state.Clusters).To(HaveLen(2))
+			Expect(state.Policies).To(HaveLen(1))
+			Expect(state.Policies[0].ObjID).To(Equal(objID))
+			Expect(state.Policies[0].SelectedPods).To(Equal([]PodState{
+				{ObjID: remotecluster.ObjID(clusterID2, "default", "pod1", "pod-uid1"), IPs: []string{"10.1.0.1"}},
+			}))
+			Expect(state.Policies[0].Wanted).ToNot(BeNil())
+			Expect(state.Policies[0].Received).To(BeNil())
+			Expect(state.Policies[0].InSync).To(BeFalse())
+		})
+
+		It("Should filter by cluster, namespace and policy", func() {
+			Expect(cgController.snapshotState(StateFilter{Cluster: clusterID1}).Policies).To(HaveLen(1))
+			Expect(cgController.snapshotState(StateFilter{Cluster: clusterID2}).Policies).To(BeEmpty())
+			Expect(cgController.snapshotState(StateFilter{Cluster: clusterID2}).Clusters).To(HaveLen(1))
+			Expect(cgController.snapshotState(StateFilter{Namespace: "other"}).Policies).To(BeEmpty())
+			Expect(cgController.snapshotState(StateFilter{Policy: "np1"}).Policies).To(HaveLen(1))
+			Expect(cgController.snapshotState(StateFilter{Policy: objID}).Policies).To(HaveLen(1))
+		})
+
+		It("Should be served by the processing loop to authenticated requests only", func() {
+			cgController.SetDebugToken("secret")
+			go cgController.processLoop(stopChan)
+			DeferCleanup(func() { close(stopChan) })
+
+			serve := func(token string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", "/debug/state?policy=np1", http.NoBody)
+				req.Header.Set("Authorization", "Bearer "+token)
+				resp := httptest.NewRecorder()
+				cgController.authorized(cgController.serveState)(resp, req)
+
+				return resp
+			}
+
+			Expect(serve("wrong").Code).To(Equal(http.StatusUnauthorized))
+
+			resp := serve("secret")
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			state := &State{}
+			Expect(json.Unmarshal(resp.Body.Bytes(), state)).To(Succeed())
+			Expect(state.Policies).To(HaveLen(1))
+		})
+
+		It("Should give up when the processing loop doesn't answer", func() {
+			_, answered := cgController.requestState(StateFilter{}, 10*time.Millisecond)
+			Expect(answered).To(BeFalse())
+		})
+	})
+
+	Context("Events on original policies", func() {
+		var (
+			clientSet *fake.Clientset
+			original  *v1net.NetworkPolicy
+		)
+
+		BeforeEach(func() {
+			clientSet = fake.NewSimpleClientset()
+			original = &v1net.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"},
+				Spec: v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{
+					From: []v1net.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+				}}},
+			}
+		})
+
+		JustBeforeEach(func() {
+			cgController.addCluster(clusterID1, clientSet)
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+			rc1, rc2 := cgController.remoteClusters[clusterID1], cgController.remoteClusters[clusterID2]
+			DeferCleanup(rc1.Stop)
+			DeferCleanup(rc2.Stop)
+
+			cgController.processEvent(rc1.NewAddEvent(original))
+			cgController.processEvent(rc2.NewAddEvent(&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod-uid1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.1.0.1"},
+			}))
+		})
+
+		eventReasons := func() []string {
+			events, err := clientSet.CoreV1().Events("default").List(context.TODO(), metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			reasons := []string{}
+			for i := range events.Items {
+				if events.Items[i].InvolvedObject.Name == "np1" {
+					reasons = append(reasons, events.Items[i].Reason)
+				}
+			}
+
+			return reasons
+		}
+
+		When("the generated policy is distributed", func() {
+			BeforeEach(func() {
+				clientSet.PrependReactor("patch", "networkpolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, &v1net.NetworkPolicy{}, nil
+				})
+			})
+
+			It("Should record it", func() {
+				cgController.processPoliciesNeedingDistribution()
+				Eventually(eventReasons).Should(ConsistOf(remotecluster.ReasonPolicyGenerated))
+			})
+		})
+
+		When("the generated policy can't be distributed", func() {
+			BeforeEach(func() {
+				clientSet.PrependReactor("patch", "networkpolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(v1net.Resource("networkpolicies"), "coastguard-uid1", nil)
+				})
+			})
+
+			It("Should record the failure", func() {
+				cgController.processPoliciesNeedingDistribution()
+				Eventually(eventReasons).Should(ConsistOf(remotecluster.ReasonDistributionFailed))
+			})
+		})
+
+		When("the policy has peers which can't select remote pods", func() {
+			BeforeEach(func() {
+				original.Spec.Ingress[0].From = append(original.Spec.Ingress[0].From, v1net.NetworkPolicyPeer{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}},
+				})
+			})
+
+			It("Should warn about them", func() {
+				Eventually(eventReasons).Should(ConsistOf(remotecluster.ReasonUnsupportedPeer))
+			})
+
+			Context("in dry run", func() {
+				BeforeEach(func() {
+					cgController.SetDryRun(true)
+				})
+
+				It("Should not warn about them", func() {
+					Consistently(eventReasons).Should(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Context("Status annotations", func() {
+		const objID = clusterID1 + ":default/np1/uid1"
+
+		var clientSet *fake.Clientset
+
+		BeforeEach(func() {
+			original := &v1net.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"},
+				Spec: v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{
+					From: []v1net.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+				}}},
+			}
+
+			clientSet = fake.NewSimpleClientset(original)
+			cgController.addCluster(clusterID1, clientSet)
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+			rc1, rc2 := cgController.remoteClusters[clusterID1], cgController.remoteClusters[clusterID2]
+			DeferCleanup(rc1.Stop)
+			DeferCleanup(rc2.Stop)
+
+			cgController.SetStatusAnnotations(true)
+			cgController.processEvent(rc1.NewAddEvent(original))
+			cgController.processEvent(rc2.NewAddEvent(&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod-uid1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.1.0.1"},
+			}))
+		})
+
+		getStatus := func() *networkpolicy.Status {
+			np, err := clientSet.NetworkingV1().NetworkPolicies("default").Get(context.TODO(), "np1", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			return networkpolicy.CurrentStatus(np)
+		}
+
+		It("Should annotate the effect once it's settled, and only when it changes", func() {
+			rnp := cgController.remoteNetworkPolicies[objID]
+
+			By("Waiting for the generated policy to show up in the cluster")
+			cgController.updatePolicyStatuses()
+			Expect(getStatus()).To(BeNil())
+
+			cgController.remoteGenNetworkPolicies[objID] = &remoteGeneratedNetworkPolicy{
+				cluster: rnp.Cluster, np: rnp.GeneratedPolicy.DeepCopy(),
+			}
+			cgController.updatePolicyStatuses()
+
+			status := getStatus()
+			Expect(status).ToNot(BeNil())
+			Expect(status.GeneratedPolicies).To(Equal([]string{rnp.GeneratedPolicy.Name}))
+			Expect(status.Peers).To(Equal(map[string]int{clusterID2: 1}))
+			Expect(status.LastSyncTime).ToNot(BeEmpty())
+
+			By("Leaving an unchanged status alone")
+			annotated, err := clientSet.NetworkingV1().NetworkPolicies("default").Get(context.TODO(), "np1", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			cgController.processEvent(rnp.Cluster.NewUpdateEvent(rnp.Np, annotated))
+			clientSet.ClearActions()
+			cgController.updatePolicyStatuses()
+			Expect(clientSet.Actions()).To(BeEmpty())
+		})
+	})
+
+	Context("Tracing", func() {
+		var exporter *tracetest.InMemoryExporter
+
+		BeforeEach(func() {
+			exporter = tracetest.NewInMemoryExporter()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+			DeferCleanup(otel.SetTracerProvider, noop.NewTracerProvider())
+		})
+
+		It("Should trace a pod change from its event to the distribution of the generated policy", func() {
+			clientSet := fake.NewSimpleClientset()
+			clientSet.PrependReactor("patch", "networkpolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &v1net.NetworkPolicy{}, nil
+			})
+
+			cgController.addCluster(clusterID1, clientSet)
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+			rc1, rc2 := cgController.remoteClusters[clusterID1], cgController.remoteClusters[clusterID2]
+			DeferCleanup(rc1.Stop)
+			DeferCleanup(rc2.Stop)
+			cgController.onClusterFinishedSyncing(rc1)
+			cgController.onClusterFinishedSyncing(rc2)
+
+			var event *remotecluster.Event
+
+			rc1.OnAdd(&v1net.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"},
+				Spec: v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{
+					From: []v1net.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+				}}},
+			}, false)
+			Expect(cgController.clusterEvents).To(Receive(&event))
+			cgController.processEvent(event)
+
+			rc2.OnAdd(&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod-uid1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.1.0.1"},
+			}, false)
+			Expect(cgController.clusterEvents).To(Receive(&event))
+			cgController.processEvent(event)
+			cgController.processPoliciesNeedingDistribution()
+
+			spans := exporter.GetSpans()
+			distribute := spanNamed(spans, "RemoteCluster.Distribute")
+			Expect(distribute).ToNot(BeNil())
+			Expect(distribute.Attributes).To(ContainElement(tracing.ClusterKey.String(clusterID1)))
+
+			podAdded := spanNamed(spans, "RemoteCluster.OnAdd", tracing.PodKey.String(clusterID2+":default/pod1/pod-uid1"))
+			Expect(podAdded).ToNot(BeNil())
+
+			names := []string{}
+			for i := range spans {
+				if spans[i].SpanContext.TraceID() == podAdded.SpanContext.TraceID() {
+					names = append(names, spans[i].Name)
+				}
+			}
+
+			Expect(names).To(ConsistOf("RemoteCluster.OnAdd", "CoastguardController.processEvent",
+				"RemoteNetworkPolicy.updateGeneratedPolicy", "RemoteCluster.Distribute"))
+		})
+	})
+
+	Context("Audit", func() {
+		It("Should record the generated policy changes with the events which triggered them", func() {
+			sink := &fakeAuditSink{}
+			cgController.SetAuditSink(sink)
+
+			clientSet := fake.NewSimpleClientset()
+			clientSet.PrependReactor("patch", "networkpolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &v1net.NetworkPolicy{}, nil
+			})
+
+			cgController.addCluster(clusterID1, clientSet)
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+			rc1, rc2 := cgController.remoteClusters[clusterID1], cgController.remoteClusters[clusterID2]
+			DeferCleanup(rc1.Stop)
+			DeferCleanup(rc2.Stop)
+
+			const objID = clusterID1 + ":default/np1/uid1"
+
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod-uid1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.1.0.1"},
+			}
+			podObjID := clusterID2 + ":default/pod1/pod-uid1"
+
+			cgController.processEvent(rc1.NewAddEvent(&v1net.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"},
+				Spec: v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{
+					From: []v1net.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+				}}},
+			}))
+			cgController.processEvent(rc2.NewAddEvent(pod))
+			cgController.processPoliciesNeedingDistribution()
+
+			Expect(sink.records).To(HaveLen(1))
+			Expect(sink.records[0].Operation).To(Equal(audit.OperationCreate))
+			Expect(sink.records[0].OriginatingObjID).To(Equal(objID))
+			Expect(sink.records[0].Cluster).To(Equal(clusterID1))
+			Expect(sink.records[0].AddedCIDRs).To(Equal([]string{"10.1.0.1/32"}))
+			Expect(sink.records[0].RemovedCIDRs).To(BeEmpty())
+			Expect(sink.records[0].Trigger).To(Equal(audit.Trigger{
+				Event: string(remotecluster.AddEvent), ObjType: string(remotecluster.Pod), ObjID: podObjID,
+			}))
+
+			generated := cgController.remoteNetworkPolicies[objID].GeneratedPolicy.DeepCopy()
+			Expect(clientSet.Tracker().Add(generated)).To(Succeed())
+			cgController.remoteGenNetworkPolicies[objID] = &remoteGeneratedNetworkPolicy{cluster: rc1, np: generated}
+
+			cgController.processEvent(rc2.NewDeleteEvent(pod))
+			cgController.processPoliciesNeedingDelete()
+
+			Expect(sink.records).To(HaveLen(2))
+			Expect(sink.records[1].Operation).To(Equal(audit.OperationDelete))
+			Expect(sink.records[1].Name).To(Equal(generated.Name))
+			Expect(sink.records[1].AddedCIDRs).To(BeEmpty())
+			Expect(sink.records[1].RemovedCIDRs).To(Equal([]string{"10.1.0.1/32"}))
+			Expect(sink.records[1].Trigger.Event).To(Equal(string(remotecluster.DeleteEvent)))
+			Expect(sink.records[1].Trigger.ObjID).To(Equal(podObjID))
+		})
+	})
+
+	Context("Dry run", func() {
+		It("Should plan the generated policy changes without writing anything", func() {
+			cgController.SetDryRun(true)
+
+			clientSet := fake.NewSimpleClientset()
+			cgController.addCluster(clusterID1, clientSet)
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+			rc1, rc2 := cgController.remoteClusters[clusterID1], cgController.remoteClusters[clusterID2]
+			DeferCleanup(rc1.Stop)
+			DeferCleanup(rc2.Stop)
+			cgController.onClusterFinishedSyncing(rc1)
+			cgController.onClusterFinishedSyncing(rc2)
+
+			const objID = clusterID1 + ":default/np1/uid1"
+
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod-uid1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.1.0.1"},
+			}
+
+			cgController.processEvent(rc1.NewAddEvent(&v1net.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"},
+				Spec: v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{
+					From: []v1net.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+				}}},
+			}))
+			cgController.processEvent(rc2.NewAddEvent(pod))
+
+			writes := func() []k8stesting.Action {
+				actions := []k8stesting.Action{}
+				for _, action := range clientSet.Actions() {
+					if action.GetVerb() != "get" && action.GetVerb() != "list" && action.GetVerb() != "watch" {
+						actions = append(actions, action)
+					}
+				}
+
+				return actions
+			}
+
+			cgController.syncGeneratedPolicies()
+			Expect(writes()).To(BeEmpty())
+			Expect(cgController.plannedChanges).To(HaveKey(objID))
+			Expect(cgController.plannedChanges[objID].Operation).To(Equal(audit.OperationCreate))
+			Expect(cgController.plannedChanges[objID].AddedCIDRs).To(Equal([]string{"10.1.0.1/32"}))
+			Expect(cgController.plannedChanges[objID].Desired).ToNot(BeNil())
+
+			By("Planning the deletion of a generated policy which isn't wanted anymore")
+			generated := cgController.remoteNetworkPolicies[objID].GeneratedPolicy.DeepCopy()
+			cgController.remoteGenNetworkPolicies[objID] = &remoteGeneratedNetworkPolicy{cluster: rc1, np: generated}
+			cgController.processEvent(rc2.NewDeleteEvent(pod))
+
+			cgController.syncGeneratedPolicies()
+			Expect(writes()).To(BeEmpty())
+			Expect(cgController.plannedChanges[objID].Operation).To(Equal(audit.OperationDelete))
+			Expect(cgController.plannedChanges[objID].RemovedCIDRs).To(Equal([]string{"10.1.0.1/32"}))
+			Expect(cgController.plannedChanges[objID].Actual).To(Equal(generated))
+
+			By("Serving the planned changes")
+			go cgController.processLoop(stopChan)
+			DeferCleanup(func() { close(stopChan) })
+
+			cgController.SetDebugToken("secret")
+
+			resp := httptest.NewRecorder()
+			cgController.authorized(cgController.servePlan)(resp, httptest.NewRequest("GET", "/dryrun?policy=np1", http.NoBody))
+			Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+
+			req := httptest.NewRequest("GET", "/dryrun?policy=np1", http.NoBody)
+			req.Header.Set("Authorization", "Bearer secret")
+			resp = httptest.NewRecorder()
+			cgController.authorized(cgController.servePlan)(resp, req)
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			changes := []PlannedChange{}
+			Expect(json.Unmarshal(resp.Body.Bytes(), &changes)).To(Succeed())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Operation).To(Equal(audit.OperationDelete))
+			Expect(changes[0].OriginatingObjID).To(Equal(objID))
+		})
+	})
+
+	Context("Metrics", func() {
+		It("Should count the events and writes, and time the propagation of pod changes", func() {
+			clientSet := fake.NewSimpleClientset()
+			clientSet.PrependReactor("patch", "networkpolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &v1net.NetworkPolicy{}, nil
+			})
+
+			cgController.addCluster(clusterID1, clientSet)
+			cgController.addCluster(clusterID2, fake.NewSimpleClientset())
+			rc1, rc2 := cgController.remoteClusters[clusterID1], cgController.remoteClusters[clusterID2]
+			DeferCleanup(rc1.Stop)
+			DeferCleanup(rc2.Stop)
+
+			podEvents := metrics.EventsProcessed.WithLabelValues(clusterID2, string(remotecluster.Pod), string(remotecluster.AddEvent))
+			applied := metrics.Writes.WithLabelValues(clusterID1, metrics.OperationApply, metrics.ResultSuccess)
+			podEventsBefore, appliedBefore := testutil.ToFloat64(podEvents), testutil.ToFloat64(applied)
+
+			cgController.processEvent(rc1.NewAddEvent(&v1net.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "np1", UID: "uid1"},
+				Spec: v1net.NetworkPolicySpec{Ingress: []v1net.NetworkPolicyIngressRule{{
+					From: []v1net.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+				}}},
+			}))
+			cgController.processEvent(rc2.NewAddEvent(&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod-uid1"},
+				Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: "10.1.0.1"},
+			}))
+			Expect(testutil.ToFloat64(podEvents)).To(Equal(podEventsBefore + 1))
+			Expect(cgController.propagationStart).To(HaveLen(1))
+
+			cgController.processPoliciesNeedingDistribution()
+			cgController.updatePolicyMetrics()
+			Expect(testutil.ToFloat64(applied)).To(Equal(appliedBefore + 1))
+			Expect(cgController.propagationStart).To(BeEmpty())
+			Expect(testutil.ToFloat64(metrics.GeneratedPolicies.WithLabelValues(clusterID1))).To(Equal(1.0))
+		})
 	})
 
 	Context("Controller and remoteCluster interactions", func() {
@@ -66,6 +957,18 @@ var _ = Describe("Coastguard Controller", func() {
 			cgController.addCluster(clusterID2, clientSet)
 		})
 
+		It("Should cache the updated pod", func() {
+			remoteCluster := cgController.remoteClusters[clusterID1]
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", Labels: map[string]string{"app": "old"}}}
+			updated := pod.DeepCopy()
+			updated.Labels["app"] = "new"
+
+			cgController.processEvent(remoteCluster.NewAddEvent(pod))
+			event := remoteCluster.NewUpdateEvent(pod, updated)
+			cgController.processEvent(event)
+			Expect(cgController.remotePods[event.ObjID].Pod.Labels).To(HaveKeyWithValue("app", "new"))
+		})
+
 		It("Should connect remoteCluster channel to controller once it is fully synchronized", func() {
 			remoteCluster := cgController.remoteClusters[clusterID1]
 
@@ -99,6 +1002,48 @@ var _ = Describe("Coastguard Controller", func() {
 	})
 })
 
+func newGeneratedPolicy(name, originalUID, objID string) *v1net.NetworkPolicy {
+	return &v1net.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels: map[string]string{
+				networkpolicy.GeneratedPolicyLabelSelector: originalUID,
+				remotecluster.ManagedByLabel:               remotecluster.ManagedByValue,
+			},
+			Annotations: map[string]string{"submariner-io/coastguard-objid": objID},
+		},
+	}
+}
+
+// spanNamed returns the first span named name with all attrs, nil if there's none.
+func spanNamed(spans tracetest.SpanStubs, name string, attrs ...attribute.KeyValue) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name != name {
+			continue
+		}
+
+		if ok, _ := ContainElements(attrs).Match(spans[i].Attributes); ok || len(attrs) == 0 {
+			return &spans[i]
+		}
+	}
+
+	return nil
+}
+
+type fakeAuditSink struct {
+	records []*audit.Record
+}
+
+func (s *fakeAuditSink) Write(record *audit.Record) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *fakeAuditSink) Close() error {
+	return nil
+}
+
 func TestController(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Coastguard: Controller suite")
@@ -0,0 +1,229 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
+	v1net "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+)
+
+// stateTimeout is how long a state request waits for the processing loop.
+const stateTimeout = 10 * time.Second
+
+// State is a snapshot of what coastguard believes.
+type State struct {
+	Clusters []ClusterStatus `json:"clusters"`
+	Policies []PolicyState   `json:"policies"`
+}
+
+// PolicyState is what coastguard believes about an original policy, or a generated policy whose original is unknown.
+type PolicyState struct {
+	ObjID     string `json:"objID"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	SelectedPods           []PodState `json:"selectedPods,omitempty"`
+	SelectedEndpointSlices []string   `json:"selectedEndpointSlices,omitempty"`
+
+	// Wanted is the generated policy we want in the cluster, and Received the one we last saw there
+	Wanted   *v1net.NetworkPolicy `json:"wanted,omitempty"`
+	Received *v1net.NetworkPolicy `json:"received,omitempty"`
+	InSync   bool                 `json:"inSync"`
+}
+
+type PodState struct {
+	ObjID string   `json:"objID"`
+	IPs   []string `json:"ips,omitempty"`
+}
+
+// StateFilter selects the policies of a State, empty fields select everything. Policy matches the name or
+// the ObjID of the original policy.
+type StateFilter struct {
+	Cluster   string
+	Namespace string
+	Policy    string
+}
+
+type stateRequest struct {
+	filter StateFilter
+	reply  chan *State
+}
+
+// SetDebugToken enables /debug/state, and /dryrun in dry run, for requests bearing the token, it must be
+// called before Run.
+func (c *CoastguardController) SetDebugToken(token string) {
+	c.debugToken = token
+}
+
+func (f *StateFilter) matches(state *PolicyState) bool {
+	return (f.Cluster == "" || f.Cluster == state.Cluster) &&
+		(f.Namespace == "" || f.Namespace == state.Namespace) &&
+		(f.Policy == "" || f.Policy == state.Name || f.Policy == state.ObjID)
+}
+
+// snapshotState is called by the processing loop, which owns the caches, so the snapshot is consistent.
+func (c *CoastguardController) snapshotState(filter StateFilter) *State {
+	state := &State{Clusters: []ClusterStatus{}, Policies: []PolicyState{}}
+
+	for _, status := range c.ClusterStatuses() {
+		if filter.Cluster == "" || filter.Cluster == status.ClusterID {
+			state.Clusters = append(state.Clusters, status)
+		}
+	}
+
+	for objID, rnp := range c.remoteNetworkPolicies {
+		policyState := PolicyState{
+			ObjID:                  objID,
+			Cluster:                rnp.Cluster.ClusterID,
+			Namespace:              rnp.Np.Namespace,
+			Name:                   rnp.Np.Name,
+			SelectedPods:           podStates(rnp.SelectedPods()),
+			SelectedEndpointSlices: rnp.SelectedEndpointSlices(),
+			Wanted:                 rnp.GeneratedPolicy.DeepCopy(),
+		}
+
+		if rgp, exists := c.remoteGenNetworkPolicies[objID]; exists {
+			policyState.Received = rgp.np.DeepCopy()
+		}
+
+		policyState.InSync = policyState.Wanted == nil && policyState.Received == nil ||
+			policyState.Wanted != nil && policyState.Received != nil && !networkpolicy.HasDrifted(policyState.Received, policyState.Wanted)
+
+		if filter.matches(&policyState) {
+			state.Policies = append(state.Policies, policyState)
+		}
+	}
+
+	for objID, rgp := range c.remoteGenNetworkPolicies {
+		if _, exists := c.remoteNetworkPolicies[objID]; exists {
+			continue
+		}
+
+		policyState := PolicyState{
+			ObjID:     objID,
+			Cluster:   rgp.cluster.ClusterID,
+			Namespace: rgp.np.Namespace,
+			Received:  rgp.np.DeepCopy(),
+		}
+
+		if filter.matches(&policyState) {
+			state.Policies = append(state.Policies, policyState)
+		}
+	}
+
+	sort.Slice(state.Policies, func(i, j int) bool {
+		return state.Policies[i].ObjID < state.Policies[j].ObjID
+	})
+
+	return state
+}
+
+func podStates(pods []*networkpolicy.RemotePod) []PodState {
+	states := make([]PodState, len(pods))
+
+	for i, pod := range pods {
+		states[i].ObjID = pod.ObjID
+
+		for _, podIP := range pod.Pod.Status.PodIPs {
+			states[i].IPs = append(states[i].IPs, podIP.IP)
+		}
+	}
+
+	return states
+}
+
+// requestState asks the processing loop for a snapshot, false means it didn't answer in time.
+func (c *CoastguardController) requestState(filter StateFilter, timeout time.Duration) (*State, bool) {
+	request := &stateRequest{filter: filter, reply: make(chan *State, 1)}
+	return askProcessingLoop(c.stateRequests, request, request.reply, timeout)
+}
+
+func (c *CoastguardController) serveState(w http.ResponseWriter, r *http.Request) {
+	serveFromProcessingLoop(w, r, "state", c.requestState)
+}
+
+// authorized only lets through the requests bearing the debug token.
+func (c *CoastguardController) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.debugToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// askProcessingLoop sends the request to the processing loop and waits for its reply, false means it didn't
+// answer in time.
+func askProcessingLoop[R, T any](requests chan<- R, request R, reply <-chan T, timeout time.Duration) (T, bool) {
+	var none T
+
+	timer := time.NewTimer(timeout)
+
+	defer timer.Stop()
+
+	select {
+	case requests <- request:
+	case <-timer.C:
+		return none, false
+	}
+
+	select {
+	case answer := <-reply:
+		return answer, true
+	case <-timer.C:
+		return none, false
+	}
+}
+
+// serveFromProcessingLoop serves as JSON what the processing loop answers for the filter of the query.
+func serveFromProcessingLoop[T any](w http.ResponseWriter, r *http.Request, what string,
+	request func(StateFilter, time.Duration) (T, bool),
+) {
+	query := r.URL.Query()
+
+	answer, answered := request(StateFilter{
+		Cluster:   query.Get("cluster"),
+		Namespace: query.Get("namespace"),
+		Policy:    query.Get("policy"),
+	}, stateTimeout)
+	if !answered {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("the processing loop didn't answer in time"))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(answer); err != nil {
+		klog.ErrorS(err, "Error encoding the "+what)
+	}
+}
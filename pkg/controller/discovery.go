@@ -19,39 +19,186 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/metrics"
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
 	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/sourceip"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 )
 
 func (c *CoastguardController) OnAdd(clusterID string, kubeConfig *rest.Config) {
-	klog.Infof("adding cluster: %s", clusterID)
+	klog.InfoS("Adding cluster", "cluster", clusterID)
+
+	kubeConfig = c.rateLimited(kubeConfig)
 
 	clientSet, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
-		klog.Errorf("error creating clientset for cluster %s: %s", clusterID, err.Error())
+		klog.ErrorS(err, "Error creating the clientset", "cluster", clusterID)
 		return
 	}
 
-	c.addCluster(clusterID, clientSet)
+	rc := c.newRemoteCluster(clusterID, clientSet)
+	go c.checkPermissions(rc, clientSet)
+
+	if err := c.configureSourceIPResolver(rc, kubeConfig); err != nil {
+		klog.ErrorS(err, "Error configuring the source IP resolver, using pod IPs", "cluster", clusterID)
+	}
+
+	c.startCluster(rc)
 }
 
 func (c *CoastguardController) addCluster(clusterID string, clientSet kubernetes.Interface) {
-	rc := remotecluster.New(clusterID, clientSet)
+	c.startCluster(c.newRemoteCluster(clusterID, clientSet))
+}
+
+func (c *CoastguardController) newRemoteCluster(clusterID string, clientSet kubernetes.Interface) *remotecluster.RemoteCluster {
+	c.processingMutex.Lock()
+	scope := remotecluster.ScopeFor(c.clusterScopes, clusterID)
+	c.processingMutex.Unlock()
+
+	if scope.IsLimited() {
+		klog.InfoS("Only watching the cluster scope", "cluster", clusterID, "scope", scope.String())
+	}
+
+	return remotecluster.NewScoped(clusterID, clientSet, scope)
+}
+
+func (c *CoastguardController) configureSourceIPResolver(rc *remotecluster.RemoteCluster, kubeConfig *rest.Config) error {
+	c.processingMutex.Lock()
+	kind := sourceip.KindFor(c.sourceIPResolverKinds, rc.ClusterID)
+	c.processingMutex.Unlock()
+
+	if kind == sourceip.PodIP {
+		return nil
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return errors.Wrap(err, "error creating dynamic client")
+	}
+
+	resolver, err := sourceip.New(kind, rc.ClientSet, dynamicClient, rc.PodInformer())
+	if err != nil {
+		return errors.Wrap(err, "error creating source IP resolver")
+	}
+
+	klog.InfoS("Using a source IP resolver", "cluster", rc.ClusterID, "resolver", kind)
+	rc.SetSourceIPResolver(resolver)
+
+	return nil
+}
+
+func (c *CoastguardController) startCluster(rc *remotecluster.RemoteCluster) {
+	clusterID := rc.ClusterID
 	rc.SetEventChannel(c.clusterEvents)
 	c.processingMutex.Lock()
+	rc.SetSecondaryNetworks(networkpolicy.SecondaryNetworksFor(c.secondaryNetworks, clusterID))
 	c.remoteClusters[clusterID] = rc
 	c.processingMutex.Unlock()
+	metrics.ClusterSynced.WithLabelValues(clusterID).Set(0)
 	rc.Run(c.onClusterFinishedSyncing)
 }
 
-func (c *CoastguardController) OnUpdate(clusterID string, _ *rest.Config) {
-	klog.Infof("updating cluster: %s", clusterID)
-	klog.Fatalf("Not implemented yet")
+// OnUpdate reviews the permissions granted by the updated kubeconfig of the cluster, the informers keep
+// using the kubeconfig the cluster was added with.
+func (c *CoastguardController) OnUpdate(clusterID string, kubeConfig *rest.Config) {
+	klog.InfoS("Updating cluster", "cluster", clusterID)
+
+	c.processingMutex.Lock()
+	rc, exists := c.remoteClusters[clusterID]
+	c.processingMutex.Unlock()
+
+	if !exists {
+		klog.InfoS("Ignoring the update of a cluster which was never added", "cluster", clusterID)
+		return
+	}
+
+	clientSet, err := kubernetes.NewForConfig(c.rateLimited(kubeConfig))
+	if err != nil {
+		klog.ErrorS(err, "Error creating the clientset", "cluster", clusterID)
+		return
+	}
+
+	go c.checkPermissions(rc, clientSet)
+}
+
+// rateLimited returns a copy of kubeConfig with the client rate limits applied, when they are set.
+func (c *CoastguardController) rateLimited(kubeConfig *rest.Config) *rest.Config {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	kubeConfig = rest.CopyConfig(kubeConfig)
+
+	if c.clientQPS > 0 {
+		kubeConfig.QPS = c.clientQPS
+	}
+
+	if c.clientBurst > 0 {
+		kubeConfig.Burst = c.clientBurst
+	}
+
+	return kubeConfig
 }
 
 func (c *CoastguardController) OnRemove(clusterID string) {
-	klog.Infof("removing cluster: %s", clusterID)
-	klog.Fatalf("Not implemented yet")
+	klog.InfoS("Removing cluster", "cluster", clusterID)
+
+	c.processingMutex.Lock()
+	rc, exists := c.remoteClusters[clusterID]
+	delete(c.remoteClusters, clusterID)
+	delete(c.syncedClusters, clusterID)
+	c.processingMutex.Unlock()
+
+	if !exists {
+		klog.InfoS("Ignoring the removal of a cluster which was never added", "cluster", clusterID)
+		return
+	}
+
+	rc.Stop()
+	metrics.RemoveCluster(clusterID)
+
+	// the processing loop forgets about the cluster objects once it's done with the events already queued
+	c.clusterEvents <- &remotecluster.Event{Cluster: rc, Type: remotecluster.DeleteEvent, ObjType: remotecluster.Cluster, ObjID: clusterID}
+}
+
+// removedCluster cleans up after an unregistered cluster: the policies generated in the cluster are deleted
+// and our finalizers released on a best effort basis, as the cluster may be unreachable, then every object of
+// the cluster is forgotten. The whole cleanup is bounded by clusterRequestTimeout, so an unreachable cluster
+// doesn't hold up the processing loop.
+func (c *CoastguardController) removedCluster(rc *remotecluster.RemoteCluster) {
+	leading := c.IsLeader()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterRequestTimeout)
+	defer cancel()
+
+	for objID, rnp := range c.remoteNetworkPolicies {
+		if !leading || c.dryRun || rnp.Cluster != rc {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			klog.ErrorS(ctx.Err(), "Giving up the cleanup of the removed cluster", "cluster", rc.ClusterID)
+			break
+		}
+
+		if rgp, exists := c.remoteGenNetworkPolicies[objID]; exists {
+			logDeleteError(objID, rc.Delete(ctx, rgp.np))
+		}
+
+		if remotecluster.HasFinalizer(rnp.Np) {
+			logFinalizerError(objID, rc.RemoveFinalizer(ctx, rnp.Np))
+		}
+	}
+
+	for _, list := range [][]interface{}{rc.GetNetworkPolicies(), rc.GetPods(), rc.GetEndpointSlices()} {
+		for _, obj := range list {
+			c.processEvent(rc.NewDeleteEvent(obj))
+		}
+	}
 }
@@ -0,0 +1,110 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/submariner-io/coastguard/pkg/audit"
+	v1net "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+)
+
+// PlannedChange is a write of a generated policy which the dry-run mode holds back, with its diff against
+// the generated policy actually in the cluster. Its time is when it was first planned.
+type PlannedChange struct {
+	audit.Record
+
+	// Actual is the generated policy in the cluster, and Desired the one coastguard would write
+	Actual  *v1net.NetworkPolicy `json:"actual,omitempty"`
+	Desired *v1net.NetworkPolicy `json:"desired,omitempty"`
+}
+
+type planRequest struct {
+	filter StateFilter
+	reply  chan []PlannedChange
+}
+
+// SetDryRun enables the dry-run mode, in which the generated policies are computed but nothing is written
+// to the clusters, the changes held back are logged and served on /dryrun instead. It must be called before Run.
+func (c *CoastguardController) SetDryRun(enabled bool) {
+	c.dryRun = enabled
+}
+
+// startPlan starts a new pass of planned changes, the changes planned again are only logged if they differ.
+func (c *CoastguardController) startPlan() {
+	c.previousPlan, c.plannedChanges = c.plannedChanges, map[string]*PlannedChange{}
+}
+
+// plan holds back the change of the generated policy of objID in cluster from actual to desired, actual is nil
+// when it would be created, and desired when it would be deleted.
+func (c *CoastguardController) plan(cluster, objID string, actual, desired *v1net.NetworkPolicy, trigger audit.Trigger) {
+	change := &PlannedChange{
+		Record:  *audit.NewRecord(cluster, objID, actual, desired, trigger),
+		Actual:  actual.DeepCopy(),
+		Desired: desired.DeepCopy(),
+	}
+
+	c.plannedChanges[objID] = change
+
+	if previous, exists := c.previousPlan[objID]; exists && previous.Operation == change.Operation &&
+		reflect.DeepEqual(previous.AddedCIDRs, change.AddedCIDRs) && reflect.DeepEqual(previous.RemovedCIDRs, change.RemovedCIDRs) {
+		change.Time = previous.Time
+		return
+	}
+
+	klog.InfoS("Dry run: would "+change.Operation+" the generated policy", "cluster", cluster, "policy", objID,
+		"generatedPolicy", change.Namespace+"/"+change.Name, "addedCIDRs", change.AddedCIDRs, "removedCIDRs", change.RemovedCIDRs,
+		"trigger", change.Trigger.Event)
+}
+
+// snapshotPlan is called by the processing loop, which owns the planned changes.
+func (c *CoastguardController) snapshotPlan(filter StateFilter) []PlannedChange {
+	changes := []PlannedChange{}
+
+	for _, change := range c.plannedChanges {
+		policyState := PolicyState{ObjID: change.OriginatingObjID, Cluster: change.Cluster, Namespace: change.Namespace}
+		if rnp, exists := c.remoteNetworkPolicies[change.OriginatingObjID]; exists {
+			policyState.Name = rnp.Np.Name
+		}
+
+		if filter.matches(&policyState) {
+			changes = append(changes, *change)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].OriginatingObjID < changes[j].OriginatingObjID
+	})
+
+	return changes
+}
+
+// requestPlan asks the processing loop for the planned changes, false means it didn't answer in time.
+func (c *CoastguardController) requestPlan(filter StateFilter, timeout time.Duration) ([]PlannedChange, bool) {
+	request := &planRequest{filter: filter, reply: make(chan []PlannedChange, 1)}
+	return askProcessingLoop(c.planRequests, request, request.reply, timeout)
+}
+
+func (c *CoastguardController) servePlan(w http.ResponseWriter, r *http.Request) {
+	serveFromProcessingLoop(w, r, "planned changes", c.requestPlan)
+}
@@ -0,0 +1,78 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1 "k8s.io/api/core/v1"
+	v1net "k8s.io/api/networking/v1"
+)
+
+// The events below are recorded on the original policies, in their home cluster, by the recorder of the cluster.
+
+func recordDistributed(rnp *networkpolicy.RemoteNetworkPolicy, updated bool) {
+	if updated {
+		rnp.Cluster.Eventf(rnp.Np, v1.EventTypeNormal, remotecluster.ReasonPolicyUpdated,
+			"Updated the generated NetworkPolicy %s, with %d peers", rnp.GeneratedPolicy.Name, countPeers(rnp.GeneratedPolicy))
+	} else {
+		rnp.Cluster.Eventf(rnp.Np, v1.EventTypeNormal, remotecluster.ReasonPolicyGenerated,
+			"Generated the NetworkPolicy %s, with %d peers", rnp.GeneratedPolicy.Name, countPeers(rnp.GeneratedPolicy))
+	}
+}
+
+func recordDistributionFailure(rnp *networkpolicy.RemoteNetworkPolicy, err error) {
+	rnp.Cluster.Eventf(rnp.Np, v1.EventTypeWarning, remotecluster.ReasonDistributionFailed,
+		"Unable to write the generated NetworkPolicy %s: %s", rnp.GeneratedPolicy.Name, err)
+}
+
+func recordWithdrawal(rnp *networkpolicy.RemoteNetworkPolicy, generated *v1net.NetworkPolicy, err error) {
+	if err != nil {
+		rnp.Cluster.Eventf(rnp.Np, v1.EventTypeWarning, remotecluster.ReasonWithdrawalFailed,
+			"Unable to delete the generated NetworkPolicy %s: %s", generated.Name, err)
+	} else {
+		rnp.Cluster.Eventf(rnp.Np, v1.EventTypeNormal, remotecluster.ReasonPolicyWithdrawn,
+			"Deleted the generated NetworkPolicy %s, no remote peer is selected anymore", generated.Name)
+	}
+}
+
+// recordUnsupportedPeers warns about the peers which can't select remote pods, when the policy is new or its
+// ingress rules changed, nothing is recorded in dry run.
+func (c *CoastguardController) recordUnsupportedPeers(rnp *networkpolicy.RemoteNetworkPolicy, oldNp *v1net.NetworkPolicy) {
+	if oldNp != nil && reflect.DeepEqual(oldNp.Spec.Ingress, rnp.Np.Spec.Ingress) || !c.IsLeader() || c.dryRun {
+		return
+	}
+
+	if unsupported := networkpolicy.UnsupportedPeers(rnp.Np); len(unsupported) > 0 {
+		rnp.Cluster.Eventf(rnp.Np, v1.EventTypeWarning, remotecluster.ReasonUnsupportedPeer,
+			"These peers can't select pods of other clusters yet: %s", strings.Join(unsupported, "; "))
+	}
+}
+
+func countPeers(np *v1net.NetworkPolicy) int {
+	peers := 0
+	for i := range np.Spec.Ingress {
+		peers += len(np.Spec.Ingress[i].From)
+	}
+
+	return peers
+}
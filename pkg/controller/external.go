@@ -0,0 +1,69 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+
+	"github.com/submariner-io/coastguard/pkg/externalworkloads"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1 "k8s.io/api/core/v1"
+)
+
+// applyExternalWorkloads turns the differences between the current and the new external workload
+// registries into pod events of the virtual external cluster, so they are processed like any other
+// remote pod. A nil registry removes all the external workloads.
+func (c *CoastguardController) applyExternalWorkloads(registry *externalworkloads.Registry) {
+	if registry == nil || c.externalCluster == nil || c.externalCluster.ClusterID != registry.ClusterID {
+		// the virtual cluster changed, all the known workloads must go away first
+		for _, pod := range c.externalPods {
+			c.processEvent(c.externalCluster.NewDeleteEvent(pod))
+		}
+
+		c.externalPods = make(map[string]*v1.Pod)
+		c.externalCluster = nil
+
+		if registry == nil {
+			return
+		}
+
+		c.externalCluster = remotecluster.NewVirtual(registry.ClusterID)
+	}
+
+	newPods := make(map[string]*v1.Pod)
+
+	for _, pod := range registry.Pods() {
+		objID := remotecluster.ObjID(c.externalCluster.ClusterID, pod.Namespace, pod.Name, pod.UID)
+		newPods[objID] = pod
+
+		if oldPod, exists := c.externalPods[objID]; !exists {
+			c.processEvent(c.externalCluster.NewAddEvent(pod))
+		} else if !reflect.DeepEqual(oldPod, pod) {
+			c.processEvent(c.externalCluster.NewUpdateEvent(oldPod, pod))
+		}
+	}
+
+	for objID, pod := range c.externalPods {
+		if _, exists := newPods[objID]; !exists {
+			c.processEvent(c.externalCluster.NewDeleteEvent(pod))
+		}
+	}
+
+	c.externalPods = newPods
+}
@@ -0,0 +1,225 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/submariner-io/coastguard/pkg/audit"
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1net "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+)
+
+// GarbageCollection configures the periodic deletion of orphaned generated policies, whose original
+// policy no longer exists, i.e. because it was deleted while coastguard was down.
+type GarbageCollection struct {
+	// Period between garbage collection passes, zero disables the garbage collection
+	Period time.Duration
+
+	// DryRun only logs the orphaned policies which would be deleted
+	DryRun bool
+
+	// MaxDeletions is the maximum number of orphaned policies a single pass may delete, a pass
+	// finding more deletes none of them, as it's more likely a sign of something going wrong
+	MaxDeletions int
+}
+
+// clusterRequestTimeout bounds the requests the processing loop makes to a cluster, which may be unreachable.
+const clusterRequestTimeout = 30 * time.Second
+
+// garbageCollectionTimeout bounds the listing of the generated policies of every cluster, which runs in the
+// background so unreachable clusters don't hold up the processing loop.
+const garbageCollectionTimeout = time.Minute
+
+type clusterPolicy struct {
+	cluster *remotecluster.RemoteCluster
+	np      *v1net.NetworkPolicy
+}
+
+// generatedPolicyList is the result of the background listing of the generated policies, handed over to the
+// processing loop.
+type generatedPolicyList struct {
+	gc       GarbageCollection
+	policies []clusterPolicy
+}
+
+// SetGarbageCollection configures the garbage collection of orphaned generated policies,
+// it must be called before Run.
+func (c *CoastguardController) SetGarbageCollection(gc GarbageCollection) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.garbageCollection = gc
+}
+
+func (c *CoastguardController) garbageCollectionConfig() GarbageCollection {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	return c.garbageCollection
+}
+
+// collectGarbage starts a garbage collection pass: the generated policies of the clusters we own are listed in
+// the background, then handed over to the processing loop which deletes the orphaned ones.
+func (c *CoastguardController) collectGarbage() {
+	if !c.IsLeader() {
+		return
+	}
+
+	if !c.AllClustersSynced() {
+		klog.InfoS("Skipping garbage collection until all the clusters are synced")
+		return
+	}
+
+	if c.garbageCollecting {
+		klog.InfoS("Skipping garbage collection, the previous pass is still listing the generated policies")
+		return
+	}
+
+	gc := c.garbageCollectionConfig()
+	gc.DryRun = gc.DryRun || c.dryRun
+
+	clusters := []*remotecluster.RemoteCluster{}
+
+	for _, cluster := range c.clusters() {
+		if c.ownsCluster(cluster.ClusterID) {
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	c.garbageCollecting = true
+
+	go func() {
+		// the buffer holds the list of the single pass running
+		c.generatedPolicyLists <- &generatedPolicyList{gc: gc, policies: listGeneratedPolicies(clusters)}
+	}()
+}
+
+// listGeneratedPolicies lists the generated policies of the clusters concurrently, within garbageCollectionTimeout,
+// the clusters which can't be listed are skipped.
+func listGeneratedPolicies(clusters []*remotecluster.RemoteCluster) []clusterPolicy {
+	ctx, cancel := context.WithTimeout(context.Background(), garbageCollectionTimeout)
+	defer cancel()
+
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+
+	policies := []clusterPolicy{}
+
+	for _, cluster := range clusters {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			nps, err := cluster.ListNetworkPolicies(ctx, networkpolicy.GeneratedPolicyLabelSelector)
+			if err != nil {
+				klog.ErrorS(err, "Unable to list the generated policies, skipping the cluster", "cluster", cluster.ClusterID)
+				return
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			for i := range nps {
+				policies = append(policies, clusterPolicy{cluster: cluster, np: &nps[i]})
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return policies
+}
+
+// deleteOrphanedPolicies deletes the listed generated policies whose original policy doesn't exist anymore,
+// the deletions are bounded by clusterRequestTimeout overall.
+func (c *CoastguardController) deleteOrphanedPolicies(gc GarbageCollection, policies []clusterPolicy) {
+	orphans := []clusterPolicy{}
+
+	for _, policy := range policies {
+		if c.isOrphaned(policy.cluster, policy.np) {
+			orphans = append(orphans, policy)
+		}
+	}
+
+	if len(orphans) > gc.MaxDeletions {
+		klog.ErrorS(nil, "Refusing to delete more orphaned generated policies than allowed in one pass", "orphans", len(orphans),
+			"maxDeletions", gc.MaxDeletions)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterRequestTimeout)
+	defer cancel()
+
+	for _, orphan := range orphans {
+		if gc.DryRun {
+			klog.InfoS("Dry run: would delete orphaned generated policy", "cluster", orphan.cluster.ClusterID,
+				"generatedPolicy", klog.KObj(orphan.np))
+
+			continue
+		}
+
+		klog.InfoS("Deleting orphaned generated policy", "cluster", orphan.cluster.ClusterID, "generatedPolicy", klog.KObj(orphan.np))
+		objID := networkpolicy.OriginatingObjID(orphan.np)
+		err := orphan.cluster.Delete(ctx, orphan.np)
+		logDeleteError(objID, err)
+
+		if err == nil {
+			c.auditChange(orphan.cluster.ClusterID, objID, orphan.np, nil, audit.Trigger{Event: audit.TriggerGarbageCollection})
+		}
+	}
+}
+
+// isOrphaned returns true if no original policy we know about generated np.
+func (c *CoastguardController) isOrphaned(cluster *remotecluster.RemoteCluster, np *v1net.NetworkPolicy) bool {
+	if objID := c.originatingObjID(cluster, np); objID != "" {
+		if _, exists := c.remoteNetworkPolicies[objID]; exists {
+			return false
+		}
+	}
+
+	uid := networkpolicy.OriginatingUID(np)
+	for _, rnp := range c.remoteNetworkPolicies {
+		if string(rnp.Np.UID) == uid {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *CoastguardController) clusters() []*remotecluster.RemoteCluster {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	clusters := make([]*remotecluster.RemoteCluster, 0, len(c.remoteClusters))
+	for _, cluster := range c.remoteClusters {
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters
+}
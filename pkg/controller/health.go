@@ -0,0 +1,104 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultLivenessTimeout leaves time for long policy syncs, the loop goes around at least every policy sync period
+// otherwise.
+const defaultLivenessTimeout = 3 * time.Minute
+
+// SetReadyQuorum sets the number of synced clusters required to be ready, 0 requires all of them.
+func (c *CoastguardController) SetReadyQuorum(quorum int) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.readyQuorum = quorum
+}
+
+// SetLivenessTimeout sets how long the processing loop may not go around before it's considered wedged.
+func (c *CoastguardController) SetLivenessTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.livenessTimeout, int64(timeout))
+}
+
+// The roles of the replica detailed by the verbose health checks.
+const (
+	RoleLeader  = "leader"
+	RoleStandby = "standby"
+)
+
+// HealthDetails is what the verbose health checks detail.
+type HealthDetails struct {
+	Role     string          `json:"role"`
+	Clusters []ClusterStatus `json:"clusters"`
+}
+
+func (c *CoastguardController) healthDetails() interface{} {
+	role := RoleStandby
+	if c.IsLeader() {
+		role = RoleLeader
+	}
+
+	return &HealthDetails{Role: role, Clusters: c.ClusterStatuses()}
+}
+
+// readiness fails on the leader until enough clusters are synced. Standbys are always ready, otherwise the rollout
+// of a single replica would never complete, as the new replica only leads once the old one is gone.
+func (c *CoastguardController) readiness() error {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	if !c.leading {
+		return nil
+	}
+
+	synced, total := len(c.syncedClusters), len(c.remoteClusters)
+
+	required := total
+	if c.readyQuorum > 0 && c.readyQuorum < total {
+		required = c.readyQuorum
+	}
+
+	if synced < required {
+		return errors.Errorf("%d of %d clusters synced, %d required", synced, total, required)
+	}
+
+	return nil
+}
+
+// beat records that the processing loop is going around.
+func (c *CoastguardController) beat() {
+	atomic.StoreInt64(&c.heartbeat, time.Now().UnixNano())
+}
+
+// liveness fails when the processing loop is wedged.
+func (c *CoastguardController) liveness() error {
+	last := time.Unix(0, atomic.LoadInt64(&c.heartbeat))
+
+	if since := time.Since(last); since > time.Duration(atomic.LoadInt64(&c.livenessTimeout)) {
+		return errors.Errorf("the processing loop hasn't gone around for %s", since.Round(time.Second))
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/metrics"
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1net "k8s.io/api/networking/v1"
+)
+
+func recordEvent(event *remotecluster.Event) {
+	clusterID := ""
+	if event.Cluster != nil {
+		clusterID = event.Cluster.ClusterID
+	}
+
+	metrics.EventsProcessed.WithLabelValues(clusterID, string(event.ObjType), string(event.Type)).Inc()
+}
+
+// recordWrite counts a write of a generated policy to a cluster, with the error it returned.
+func recordWrite(clusterID, operation string, err error) {
+	result := metrics.ResultSuccess
+
+	if errors.Is(err, remotecluster.ErrConflict) {
+		result = metrics.ResultConflict
+	} else if err != nil {
+		result = metrics.ResultError
+	}
+
+	metrics.Writes.WithLabelValues(clusterID, operation, result).Inc()
+}
+
+// applyPodChange applies a pod event to every policy, and remembers when the generated policies it
+// changed started waiting to be written.
+func (c *CoastguardController) applyPodChange(event *remotecluster.Event, apply func(rnp *networkpolicy.RemoteNetworkPolicy)) {
+	for objID, rnp := range c.remoteNetworkPolicies {
+		generated := rnp.GeneratedPolicy
+		apply(rnp)
+
+		if rnp.GeneratedPolicy == generated {
+			continue
+		}
+
+		if _, pending := c.propagationStart[objID]; !pending && rnp.GeneratedPolicy != nil && !event.Time.IsZero() {
+			c.propagationStart[objID] = event.Time
+		}
+	}
+}
+
+// writtenGeneratedPolicy records the metrics of a generated policy successfully written to its cluster.
+func (c *CoastguardController) writtenGeneratedPolicy(objID string, np *v1net.NetworkPolicy) {
+	if start, pending := c.propagationStart[objID]; pending {
+		metrics.PropagationLatency.Observe(time.Since(start).Seconds())
+		delete(c.propagationStart, objID)
+	}
+
+	metrics.PolicyPeers.Observe(float64(countPeers(np)))
+}
+
+// updatePolicyMetrics counts the generated policies wanted in each cluster.
+func (c *CoastguardController) updatePolicyMetrics() {
+	counts := map[string]int{}
+
+	for _, rc := range c.clusters() {
+		counts[rc.ClusterID] = 0
+	}
+
+	for _, rnp := range c.remoteNetworkPolicies {
+		if rnp.GeneratedPolicy != nil {
+			counts[rnp.Cluster.ClusterID]++
+		}
+	}
+
+	for clusterID, count := range counts {
+		metrics.GeneratedPolicies.WithLabelValues(clusterID).Set(float64(count))
+	}
+}
@@ -0,0 +1,117 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/submariner-io/coastguard/pkg/metrics"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/sourceip"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Reasons of the Kubernetes events recorded on the hub cluster.
+const (
+	ReasonClusterDegraded    = "ClusterDegraded"
+	ReasonClusterPermissions = "ClusterPermissionsGranted"
+)
+
+// SetHubEventRecorder sets where the events about the clusters are recorded, on the object representing
+// this replica in the hub cluster, it must be called before Run.
+func (c *CoastguardController) SetHubEventRecorder(recorder record.EventRecorder, object *v1.ObjectReference) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.hubEventRecorder = recorder
+	c.hubEventObject = object
+}
+
+func (c *CoastguardController) hubEventf(eventType, reason, messageFmt string, args ...interface{}) {
+	c.processingMutex.Lock()
+	recorder, object := c.hubEventRecorder, c.hubEventObject
+	c.processingMutex.Unlock()
+
+	if recorder != nil {
+		recorder.Eventf(object, eventType, reason, messageFmt, args...)
+	}
+}
+
+// permissionCheckTimeout bounds a permission check, which may review many namespaces on a rate limited client.
+const permissionCheckTimeout = 5 * time.Minute
+
+// checkPermissions reviews the permissions granted by clientSet on the cluster, and marks it degraded
+// when some are missing. The cluster is still watched, as whatever is permitted keeps working. It's called
+// asynchronously, the result of a check which was outrun by a later one of the same cluster is dropped.
+func (c *CoastguardController) checkPermissions(rc *remotecluster.RemoteCluster, clientSet kubernetes.Interface) {
+	scope := rc.Scope()
+
+	c.processingMutex.Lock()
+	c.permissionChecks[rc.ClusterID]++
+	check := c.permissionChecks[rc.ClusterID]
+	resolverPermissions := sourceip.RequiredPermissions(sourceip.KindFor(c.sourceIPResolverKinds, rc.ClusterID))
+	c.processingMutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), permissionCheckTimeout)
+	defer cancel()
+
+	missing, err := remotecluster.CheckPermissions(ctx, clientSet, &scope, resolverPermissions)
+	if err != nil {
+		klog.ErrorS(err, "Error checking the permissions", "cluster", rc.ClusterID)
+		return
+	}
+
+	c.processingMutex.Lock()
+	latest := c.permissionChecks[rc.ClusterID] == check
+	c.processingMutex.Unlock()
+
+	if !latest {
+		return
+	}
+
+	wasDegraded := rc.IsDegraded()
+	rc.SetMissingPermissions(missing)
+
+	if len(missing) > 0 {
+		metrics.ClusterDegraded.WithLabelValues(rc.ClusterID).Set(1)
+
+		descriptions := make([]string, len(missing))
+		for i := range missing {
+			descriptions[i] = missing[i].String()
+		}
+
+		klog.InfoS("Cluster is degraded, permissions are missing", "cluster", rc.ClusterID, "missing", descriptions)
+		c.hubEventf(v1.EventTypeWarning, ReasonClusterDegraded, "Cluster %s is missing permissions: %s", rc.ClusterID,
+			strings.Join(descriptions, ", "))
+
+		return
+	}
+
+	metrics.ClusterDegraded.WithLabelValues(rc.ClusterID).Set(0)
+
+	if wasDegraded {
+		klog.InfoS("Cluster is no longer missing permissions", "cluster", rc.ClusterID)
+		c.hubEventf(v1.EventTypeNormal, ReasonClusterPermissions, "Cluster %s is no longer missing permissions", rc.ClusterID)
+	}
+}
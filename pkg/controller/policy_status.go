@@ -0,0 +1,82 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/submariner-io/coastguard/pkg/networkpolicy"
+	"k8s.io/klog/v2"
+)
+
+// SetStatusAnnotations enables or disables the status annotation on the original policies.
+func (c *CoastguardController) SetStatusAnnotations(enabled bool) {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	c.statusAnnotations = enabled
+}
+
+// updatePolicyStatuses annotates the original policies with their cross-cluster effect, once it's settled,
+// and only when it changed. Policies which never had any effect are left alone.
+func (c *CoastguardController) updatePolicyStatuses() {
+	c.processingMutex.Lock()
+	enabled := c.statusAnnotations
+	c.processingMutex.Unlock()
+
+	if !enabled || c.dryRun {
+		return
+	}
+
+	for objID, rnp := range c.remoteNetworkPolicies {
+		if rnp.IsBeingDeleted() || !c.isSettled(objID, rnp) {
+			continue
+		}
+
+		status := rnp.Status()
+		current := networkpolicy.CurrentStatus(rnp.Np)
+
+		if current == nil && status.IsEmpty() || status.SameAs(current) {
+			continue
+		}
+
+		status.LastSyncTime = time.Now().UTC().Format(time.RFC3339)
+
+		value, err := json.Marshal(status)
+		if err != nil {
+			klog.ErrorS(err, "Error marshalling the status", "policy", objID)
+			continue
+		}
+
+		if err := rnp.Cluster.Annotate(rnp.Np, networkpolicy.StatusAnnotation, string(value)); err != nil {
+			klog.ErrorS(err, "Unable to update the status", "policy", objID)
+		}
+	}
+}
+
+// isSettled returns true if the generated policy seen in the cluster is the one we want, if any.
+func (c *CoastguardController) isSettled(objID string, rnp *networkpolicy.RemoteNetworkPolicy) bool {
+	received, exists := c.remoteGenNetworkPolicies[objID]
+	if rnp.GeneratedPolicy == nil {
+		return !exists
+	}
+
+	return exists && !networkpolicy.HasDrifted(received.np, rnp.GeneratedPolicy)
+}
@@ -0,0 +1,85 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/sharding"
+	"k8s.io/klog/v2"
+)
+
+// EnableSharding makes this replica the identity member of the shards, owning no cluster until
+// the shard members are set, it must be called before Run.
+func (c *CoastguardController) EnableSharding(identity string) {
+	c.shardIdentity = identity
+	c.shardRing = sharding.NewRing(nil)
+}
+
+// SetShardMembers hands the live shard members over to the processing loop, which rebalances
+// the clusters across them. When several member lists are set in a row only the latest one is kept.
+func (c *CoastguardController) SetShardMembers(members []string) {
+	sendLatest(c.shardMembers, members)
+}
+
+// ownsCluster returns true if this replica handles the policies of the cluster.
+func (c *CoastguardController) ownsCluster(clusterID string) bool {
+	return c.shardRing == nil || c.shardRing.Owner(clusterID) == c.shardIdentity
+}
+
+// applyShardMembers rebalances the clusters, starting to track the policies of the clusters we
+// now own from the informer caches, and forgetting those of the clusters we don't own anymore.
+func (c *CoastguardController) applyShardMembers(members []string) {
+	if c.shardRing == nil {
+		klog.InfoS("Ignoring shard members as sharding is not enabled")
+		return
+	}
+
+	previousRing := c.shardRing
+	c.shardRing = sharding.NewRing(members)
+
+	for _, rc := range c.clusters() {
+		wasOwned := previousRing.Owner(rc.ClusterID) == c.shardIdentity
+		owned := c.ownsCluster(rc.ClusterID)
+
+		if owned && !wasOwned {
+			klog.InfoS("Cluster is now handled by this replica", "cluster", rc.ClusterID)
+
+			for _, obj := range rc.GetNetworkPolicies() {
+				c.processEvent(rc.NewAddEvent(obj))
+			}
+		} else if !owned && wasOwned {
+			klog.InfoS("Cluster is now handled by another replica", "cluster", rc.ClusterID, "owner", c.shardRing.Owner(rc.ClusterID))
+			c.forgetClusterPolicies(rc)
+		}
+	}
+}
+
+func (c *CoastguardController) forgetClusterPolicies(rc *remotecluster.RemoteCluster) {
+	for objID, rnp := range c.remoteNetworkPolicies {
+		if rnp.Cluster == rc {
+			delete(c.remoteNetworkPolicies, objID)
+		}
+	}
+
+	for objID, rgnp := range c.remoteGenNetworkPolicies {
+		if rgnp.cluster == rc {
+			delete(c.remoteGenNetworkPolicies, objID)
+		}
+	}
+}
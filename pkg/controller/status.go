@@ -0,0 +1,87 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"k8s.io/klog/v2"
+)
+
+// ClusterStatus describes what we know about a cluster.
+type ClusterStatus struct {
+	ClusterID string `json:"clusterID"`
+	Synced    bool   `json:"synced"`
+
+	// Scope describes what is watched in the cluster, and WatchedNamespaces are the namespaces
+	// currently watched when they are limited
+	Scope             string   `json:"scope"`
+	ScopeLimited      bool     `json:"scopeLimited"`
+	WatchedNamespaces []string `json:"watchedNamespaces,omitempty"`
+
+	// Degraded is true when coastguard lacks the MissingPermissions on the cluster
+	Degraded           bool                       `json:"degraded"`
+	MissingPermissions []remotecluster.Permission `json:"missingPermissions,omitempty"`
+}
+
+// ClusterStatuses returns the status of every cluster, sorted by cluster ID.
+func (c *CoastguardController) ClusterStatuses() []ClusterStatus {
+	c.processingMutex.Lock()
+	defer c.processingMutex.Unlock()
+
+	statuses := make([]ClusterStatus, 0, len(c.remoteClusters))
+
+	for clusterID, rc := range c.remoteClusters {
+		_, synced := c.syncedClusters[clusterID]
+		scope := rc.Scope()
+
+		status := ClusterStatus{
+			ClusterID:    clusterID,
+			Synced:       synced,
+			Scope:        scope.String(),
+			ScopeLimited: scope.IsLimited(),
+		}
+
+		status.MissingPermissions = rc.MissingPermissions()
+		status.Degraded = len(status.MissingPermissions) > 0
+
+		if len(scope.Namespaces) > 0 || scope.NamespaceSelector != "" {
+			status.WatchedNamespaces = rc.WatchedNamespaces()
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ClusterID < statuses[j].ClusterID
+	})
+
+	return statuses
+}
+
+func (c *CoastguardController) serveClusterStatuses(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(c.ClusterStatuses()); err != nil {
+		klog.ErrorS(err, "Error encoding the cluster statuses")
+	}
+}
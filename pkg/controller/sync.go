@@ -21,56 +21,139 @@ package controller
 import (
 	"time"
 
+	"github.com/submariner-io/coastguard/pkg/audit"
+	"github.com/submariner-io/coastguard/pkg/logging"
+	"github.com/submariner-io/coastguard/pkg/metrics"
+	"github.com/submariner-io/coastguard/pkg/namespacemapping"
 	"github.com/submariner-io/coastguard/pkg/networkpolicy"
 	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/tracing"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	v1net "k8s.io/api/networking/v1"
 	"k8s.io/klog/v2"
 )
 
-const policySyncPeriod = 5 * time.Second
+const defaultPolicySyncPeriod = 5 * time.Second
+
+// SetPolicySyncPeriod hands a new period between the syncs of the generated policies over to the
+// processing loop.
+func (c *CoastguardController) SetPolicySyncPeriod(period time.Duration) {
+	sendLatest(c.policySyncPeriods, period)
+}
 
 func (c *CoastguardController) onClusterFinishedSyncing(cluster *remotecluster.RemoteCluster) {
 	c.processingMutex.Lock()
 	defer c.processingMutex.Unlock()
 
-	klog.Infof("Cluster %s finished syncing", cluster.ClusterID)
+	klog.InfoS("Cluster finished syncing", "cluster", cluster.ClusterID)
 	c.syncedClusters[cluster.ClusterID] = cluster
+	metrics.ClusterSynced.WithLabelValues(cluster.ClusterID).Set(1)
 }
 
 func (c *CoastguardController) processLoop(stopCh <-chan struct{}) {
-	policySyncTicker := time.NewTicker(policySyncPeriod)
+	policySyncTicker := time.NewTicker(defaultPolicySyncPeriod)
+	defer policySyncTicker.Stop()
+
+	// a nil channel never receives, so garbage collection stays disabled
+	var garbageCollectionCh <-chan time.Time
+
+	if period := c.garbageCollectionConfig().Period; period > 0 {
+		garbageCollectionTicker := time.NewTicker(period)
+		defer garbageCollectionTicker.Stop()
+
+		garbageCollectionCh = garbageCollectionTicker.C
+	}
 
 	for {
+		c.beat()
+
 		select {
 		case event := <-c.clusterEvents:
+			metrics.EventQueueDepth.Set(float64(len(c.clusterEvents)))
 			c.processEvent(event)
+		case mapping := <-c.namespaceMappings:
+			c.applyNamespaceMapping(mapping)
+		case registry := <-c.externalWorkloads:
+			c.applyExternalWorkloads(registry)
+		case members := <-c.shardMembers:
+			c.applyShardMembers(members)
+		case request := <-c.stateRequests:
+			request.reply <- c.snapshotState(request.filter)
+		case request := <-c.planRequests:
+			request.reply <- c.snapshotPlan(request.filter)
+		case period := <-c.policySyncPeriods:
+			klog.InfoS("Changing the policy sync period", "period", period)
+			policySyncTicker.Reset(period)
 		case <-policySyncTicker.C:
 			c.syncGeneratedPolicies()
+		case <-garbageCollectionCh:
+			c.collectGarbage()
+		case list := <-c.generatedPolicyLists:
+			c.garbageCollecting = false
+			c.deleteOrphanedPolicies(list.gc, list.policies)
 		case <-stopCh:
-			klog.Info("exited process loop")
+			klog.InfoS("Exited the process loop")
 			return
 		}
 	}
 }
 
+// applyNamespaceMapping switches to a new namespace mapping, and re-evaluates the
+// pods selected by every policy we know about.
+func (c *CoastguardController) applyNamespaceMapping(mapping *namespacemapping.Mapping) {
+	c.namespaceMapping = mapping
+
+	for objID, rnp := range c.remoteNetworkPolicies {
+		rnp = networkpolicy.NewRemoteNetworkPolicy(rnp.Np, rnp.Cluster, objID, c.remotePods,
+			c.remoteEndpointSlices, c.namespaceMapping, nil)
+		rnp.GeneratedBy.Trigger = audit.Trigger{Event: audit.TriggerNamespaceMapping}
+		c.remoteNetworkPolicies[objID] = rnp
+	}
+}
+
 func (c *CoastguardController) processEvent(event *remotecluster.Event) {
 	if event == nil {
-		klog.Error("processEvent received nil remotecluster.Event")
+		klog.ErrorS(nil, "processEvent received a nil remotecluster.Event")
 		return
 	}
 
-	klog.Infof("%s\t%s\t%s", event.Type, event.ObjType, event.ObjID)
+	_, span := tracing.Start(tracing.Context(event.SpanContext), "CoastguardController.processEvent", event.TraceAttributes()...)
+	defer span.End()
+
+	// the processing of the event continues within our span
+	event.SpanContext = span.SpanContext()
+
+	klog.V(logging.EventLevel).InfoS("Processing event", "type", event.Type, "objType", event.ObjType,
+		"cluster", event.Cluster.ClusterID, event.ObjType.LogKey(), event.ObjID)
+	recordEvent(event)
 
 	switch event.ObjType {
 	case remotecluster.NetworkPolicy:
 		c.processNetworkPolicyEvent(event)
 	case remotecluster.Pod:
 		c.processPodEvent(event)
+	case remotecluster.EndpointSlice:
+		c.processEndpointSliceEvent(event)
+	case remotecluster.SourceIPs:
+		event.Cluster.SourceIPsRefreshed()
+
+		for _, rnp := range c.remoteNetworkPolicies {
+			if rnp.TracksPodsOf(event.Cluster) {
+				rnp.Refresh(event)
+			}
+		}
+	case remotecluster.Cluster:
+		c.removedCluster(event.Cluster)
 	}
 }
 
 func (c *CoastguardController) processNetworkPolicyEvent(event *remotecluster.Event) {
+	if !c.ownsCluster(event.Cluster.ClusterID) {
+		// another replica handles the policies of this cluster
+		return
+	}
+
 	np := event.Objs[0].(*v1net.NetworkPolicy)
 	if networkpolicy.IsGenerated(np) {
 		c.processGeneratedNetworkPolicyEvent(event)
@@ -101,10 +184,38 @@ func (c *CoastguardController) processPodEvent(event *remotecluster.Event) {
 	}
 }
 
+func (c *CoastguardController) processEndpointSliceEvent(event *remotecluster.Event) {
+	switch event.Type {
+	case remotecluster.AddEvent:
+		eps := event.Objs[0].(*discoveryv1.EndpointSlice)
+		c.remoteEndpointSlices[event.ObjID] = networkpolicy.NewRemoteEndpointSlice(eps, event.Cluster, event.ObjID)
+
+		for _, np := range c.remoteNetworkPolicies {
+			np.AddedEndpointSlice(event)
+		}
+	case remotecluster.UpdateEvent:
+		eps := event.Objs[1].(*discoveryv1.EndpointSlice)
+		c.remoteEndpointSlices[event.ObjID] = networkpolicy.NewRemoteEndpointSlice(eps, event.Cluster, event.ObjID)
+
+		for _, np := range c.remoteNetworkPolicies {
+			np.UpdatedEndpointSlice(event)
+		}
+	case remotecluster.DeleteEvent:
+		for _, np := range c.remoteNetworkPolicies {
+			np.DeletedEndpointSlice(event)
+		}
+
+		delete(c.remoteEndpointSlices, event.ObjID)
+	}
+}
+
 func (c *CoastguardController) addedRemoteNetworkPolicy(event *remotecluster.Event) {
 	if rnp, exists := c.remoteNetworkPolicies[event.ObjID]; !exists {
 		np := event.Objs[0].(*v1net.NetworkPolicy)
-		c.remoteNetworkPolicies[event.ObjID] = networkpolicy.NewRemoteNetworkPolicy(np, event.Cluster, event.ObjID, c.remotePods)
+		rnp := networkpolicy.NewRemoteNetworkPolicy(np, event.Cluster, event.ObjID, c.remotePods,
+			c.remoteEndpointSlices, c.namespaceMapping, event)
+		c.remoteNetworkPolicies[event.ObjID] = rnp
+		c.recordUnsupportedPeers(rnp, nil)
 	} else {
 		c.updateRemoteNetworkPolicy(event.ToUpdatedFrom(rnp.Np))
 	}
@@ -113,8 +224,10 @@ func (c *CoastguardController) addedRemoteNetworkPolicy(event *remotecluster.Eve
 func (c *CoastguardController) updateRemoteNetworkPolicy(event *remotecluster.Event) {
 	if _, exists := c.remoteNetworkPolicies[event.ObjID]; exists {
 		np := event.Objs[1].(*v1net.NetworkPolicy)
-		rnp := networkpolicy.NewRemoteNetworkPolicy(np, event.Cluster, event.ObjID, c.remotePods)
+		rnp := networkpolicy.NewRemoteNetworkPolicy(np, event.Cluster, event.ObjID, c.remotePods,
+			c.remoteEndpointSlices, c.namespaceMapping, event)
 		c.remoteNetworkPolicies[event.ObjID] = rnp
+		c.recordUnsupportedPeers(rnp, event.Objs[0].(*v1net.NetworkPolicy))
 	} else {
 		c.addedRemoteNetworkPolicy(event.ToAdded())
 	}
@@ -123,8 +236,9 @@ func (c *CoastguardController) updateRemoteNetworkPolicy(event *remotecluster.Ev
 func (c *CoastguardController) deleteRemoteNetworkPolicy(event *remotecluster.Event) {
 	if _, exists := c.remoteNetworkPolicies[event.ObjID]; exists {
 		delete(c.remoteNetworkPolicies, event.ObjID)
+		delete(c.propagationStart, event.ObjID)
 	} else {
-		klog.Warningf("A deleteNetworkPolicy event was received for a np not in our cache: %s", event.ObjID)
+		klog.InfoS("Ignoring the deletion of a NetworkPolicy not in our cache", "policy", event.ObjID)
 	}
 }
 
@@ -132,37 +246,37 @@ func (c *CoastguardController) addedPod(event *remotecluster.Event) {
 	pod := event.Objs[0].(*v1.Pod)
 	if rp, exists := c.remotePods[event.ObjID]; !exists {
 		c.remotePods[event.ObjID] = networkpolicy.NewRemotePod(pod, event.Cluster, event.ObjID)
-		for _, np := range c.remoteNetworkPolicies {
+		c.applyPodChange(event, func(np *networkpolicy.RemoteNetworkPolicy) {
 			np.AddedPod(event)
-		}
+		})
 	} else {
-		klog.Warningf("An addPod event was received for a pod already in our cache: %s, updating instead", event.ObjID)
+		klog.V(logging.EventLevel).InfoS("Updating a Pod added while already in our cache", "pod", event.ObjID)
 		c.updatePod(event.ToUpdatedFrom(rp.Pod))
 	}
 }
 
 func (c *CoastguardController) updatePod(event *remotecluster.Event) {
 	if _, exists := c.remotePods[event.ObjID]; exists {
-		pod := event.Objs[0].(*v1.Pod)
+		pod := event.Objs[1].(*v1.Pod)
 		c.remotePods[event.ObjID] = networkpolicy.NewRemotePod(pod, event.Cluster, event.ObjID)
 
-		for _, np := range c.remoteNetworkPolicies {
+		c.applyPodChange(event, func(np *networkpolicy.RemoteNetworkPolicy) {
 			np.UpdatedPod(event)
-		}
+		})
 	} else {
-		klog.Warningf("An updatePod event was received for a pod not in our cache: %s, adding instead", event.ObjID)
+		klog.V(logging.EventLevel).InfoS("Adding a Pod updated while not in our cache", "pod", event.ObjID)
 		c.addedPod(event.ToAdded())
 	}
 }
 
 func (c *CoastguardController) deletePod(event *remotecluster.Event) {
 	if _, exists := c.remotePods[event.ObjID]; exists {
-		for _, np := range c.remoteNetworkPolicies {
+		c.applyPodChange(event, func(np *networkpolicy.RemoteNetworkPolicy) {
 			np.DeletedPod(event)
-		}
+		})
 
 		delete(c.remotePods, event.ObjID)
 	} else {
-		klog.Warningf("An deletePod event was received for a pod not in our cache: %s", event.ObjID)
+		klog.InfoS("Ignoring the deletion of a Pod not in our cache", "pod", event.ObjID)
 	}
 }
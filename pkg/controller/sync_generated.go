@@ -19,8 +19,16 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/audit"
+	"github.com/submariner-io/coastguard/pkg/logging"
+	"github.com/submariner-io/coastguard/pkg/metrics"
 	"github.com/submariner-io/coastguard/pkg/networkpolicy"
 	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/tracing"
+	v1 "k8s.io/api/core/v1"
 	v1net "k8s.io/api/networking/v1"
 	"k8s.io/klog/v2"
 )
@@ -37,29 +45,134 @@ type remoteGeneratedNetworkPolicy struct {
 }
 
 func (c *CoastguardController) syncGeneratedPolicies() {
+	if !c.IsLeader() {
+		return
+	}
+
 	if !c.AllClustersSynced() {
-		klog.Info("Skipping generated policy sync until all clusters sync has finished")
+		klog.V(logging.EventLevel).InfoS("Skipping the generated policy sync until all the clusters are synced")
 		return
 	}
 
+	if c.dryRun {
+		c.startPlan()
+	}
+
 	c.processPoliciesNeedingDistribution()
 	c.processPoliciesNeedingDelete()
+	c.updatePolicyStatuses()
+	c.updatePolicyMetrics()
 }
 
 func (c *CoastguardController) processPoliciesNeedingDistribution() {
 	for objID, rnp := range c.remoteNetworkPolicies {
+		genPolicyReceived, exists := c.remoteGenNetworkPolicies[objID]
+
+		// the finalizer must be in place whenever something is generated, even when it's in sync already,
+		// i.e. after a restart enabling the finalizers
+		if (rnp.GeneratedPolicy != nil || exists) && !c.ensureFinalizer(rnp) {
+			continue
+		}
+
 		if rnp.GeneratedPolicy != nil {
-			genPolicyReceived, exists := c.remoteGenNetworkPolicies[objID]
-			if !exists || networkpolicy.ArePolicyRulesDifferent(genPolicyReceived.np, rnp.GeneratedPolicy) {
-				err := rnp.Cluster.Distribute(rnp.GeneratedPolicy)
-				if err != nil {
-					klog.Errorf("An error happened trying to distribute a generated policy: %s", err)
+			if !exists || networkpolicy.HasDrifted(genPolicyReceived.np, rnp.GeneratedPolicy) {
+				if c.dryRun {
+					c.planDistribution(rnp, genPolicyReceived, exists)
+					continue
+				}
+
+				var err error
+
+				var previous *v1net.NetworkPolicy
+				if exists {
+					previous = genPolicyReceived.np
+				}
+
+				if exists && networkpolicy.IsModifiedByOthers(genPolicyReceived.np, rnp.GeneratedPolicy) {
+					err = c.repairGeneratedPolicy(rnp, genPolicyReceived.np)
+					recordWrite(rnp.Cluster.ClusterID, metrics.OperationRepair, err)
+
+					if err == nil {
+						c.auditChange(rnp.Cluster.ClusterID, objID, previous, rnp.GeneratedPolicy,
+							audit.Trigger{Event: audit.TriggerDriftCorrection})
+					}
+				} else {
+					err = rnp.Cluster.Distribute(tracing.Context(rnp.GeneratedBy.SpanContext), rnp.GeneratedPolicy)
+					recordWrite(rnp.Cluster.ClusterID, metrics.OperationApply, err)
+
+					if err == nil {
+						recordDistributed(rnp, exists)
+						c.auditChange(rnp.Cluster.ClusterID, objID, previous, rnp.GeneratedPolicy, rnp.GeneratedBy.Trigger)
+					}
+				}
+
+				if err == nil {
+					klog.InfoS("Distributed the generated policy", "cluster", rnp.Cluster.ClusterID, "policy", objID,
+						"generatedPolicy", klog.KObj(rnp.GeneratedPolicy))
+					c.writtenGeneratedPolicy(objID, rnp.GeneratedPolicy)
+					continue
+				}
+
+				if errors.Is(err, remotecluster.ErrConflict) {
+					klog.InfoS("Conflict distributing the generated policy", "policy", objID, "conflict", err.Error())
+				} else {
+					klog.ErrorS(err, "Error distributing the generated policy", "policy", objID)
 				}
+
+				recordDistributionFailure(rnp, err)
 			}
 		}
 	}
 }
 
+// ensureFinalizer adds the finalizer to the original policy of rnp when enabled, it returns false if it's missing
+// and couldn't be added, in which case nothing must be distributed.
+func (c *CoastguardController) ensureFinalizer(rnp *networkpolicy.RemoteNetworkPolicy) bool {
+	if !c.useFinalizers || c.dryRun || rnp.IsBeingDeleted() || remotecluster.HasFinalizer(rnp.Np) {
+		return true
+	}
+
+	if err := rnp.Cluster.AddFinalizer(context.TODO(), rnp.Np); err != nil {
+		klog.ErrorS(err, "Unable to add the finalizer, not distributing the generated policy yet", "policy", rnp.ObjID)
+		return false
+	}
+
+	return true
+}
+
+// planDistribution holds back the distribution of the generated policy of rnp in dry-run mode.
+func (c *CoastguardController) planDistribution(rnp *networkpolicy.RemoteNetworkPolicy, received *remoteGeneratedNetworkPolicy,
+	exists bool,
+) {
+	if !exists {
+		c.plan(rnp.Cluster.ClusterID, rnp.ObjID, nil, rnp.GeneratedPolicy, rnp.GeneratedBy.Trigger)
+		return
+	}
+
+	trigger := rnp.GeneratedBy.Trigger
+	if networkpolicy.IsModifiedByOthers(received.np, rnp.GeneratedPolicy) {
+		trigger = audit.Trigger{Event: audit.TriggerDriftCorrection}
+	}
+
+	c.plan(rnp.Cluster.ClusterID, rnp.ObjID, received.np, rnp.GeneratedPolicy, trigger)
+}
+
+// repairGeneratedPolicy restores the generated policy modified by others, recording the correction.
+func (c *CoastguardController) repairGeneratedPolicy(rnp *networkpolicy.RemoteNetworkPolicy, modified *v1net.NetworkPolicy) error {
+	klog.InfoS("Generated policy was modified outside of coastguard, repairing it", "cluster", rnp.Cluster.ClusterID,
+		"policy", rnp.ObjID, "generatedPolicy", klog.KObj(modified))
+
+	if err := rnp.Cluster.Repair(rnp.GeneratedPolicy); err != nil {
+		return err
+	}
+
+	metrics.DriftCorrections.WithLabelValues(rnp.Cluster.ClusterID).Inc()
+	rnp.Cluster.Eventf(modified, v1.EventTypeWarning, remotecluster.ReasonDriftCorrected,
+		"NetworkPolicy generated by coastguard from %s was modified by others, its generated content was restored", rnp.Np.Name)
+
+	return nil
+}
+
 func (c *CoastguardController) processPoliciesNeedingDelete() {
 	for objID, rnp := range c.remoteNetworkPolicies {
 		if rnp.GeneratedPolicy != nil {
@@ -67,21 +180,67 @@ func (c *CoastguardController) processPoliciesNeedingDelete() {
 		}
 
 		if rgp, exists := c.remoteGenNetworkPolicies[objID]; exists {
-			if err := rnp.Cluster.Delete(rgp.np); err != nil {
-				klog.Errorf("There was an error deleting a NetworkPolicy %s", err)
+			if c.dryRun {
+				c.plan(rnp.Cluster.ClusterID, objID, rgp.np, nil, rnp.GeneratedBy.Trigger)
+				continue
+			}
+
+			err := rnp.Cluster.Delete(context.TODO(), rgp.np)
+			recordWrite(rnp.Cluster.ClusterID, metrics.OperationDelete, err)
+			recordWithdrawal(rnp, rgp.np, err)
+			logDeleteError(objID, err)
+
+			if err == nil {
+				c.auditChange(rnp.Cluster.ClusterID, objID, rgp.np, nil, rnp.GeneratedBy.Trigger)
+			}
+
+			// a policy which isn't ours is not something we have to clean up
+			if !errors.Is(err, remotecluster.ErrConflict) {
+				continue
 			}
 		}
+
+		if remotecluster.HasFinalizer(rnp.Np) && !c.dryRun {
+			// nothing generated is left behind, the original policy can go
+			logFinalizerError(objID, rnp.Cluster.RemoveFinalizer(context.TODO(), rnp.Np))
+		}
 	}
 
 	for objID, rgnp := range c.remoteGenNetworkPolicies {
 		if _, exists := c.remoteNetworkPolicies[objID]; !exists {
-			if err := rgnp.cluster.Delete(rgnp.np); err != nil {
-				klog.Errorf("There was an error deleting a NetworkPolicy: %s", err)
+			// the original policy is gone
+			trigger := audit.Trigger{Event: string(remotecluster.DeleteEvent), ObjType: string(remotecluster.NetworkPolicy), ObjID: objID}
+
+			if c.dryRun {
+				c.plan(rgnp.cluster.ClusterID, objID, rgnp.np, nil, trigger)
+				continue
+			}
+
+			err := rgnp.cluster.Delete(context.TODO(), rgnp.np)
+			recordWrite(rgnp.cluster.ClusterID, metrics.OperationDelete, err)
+			logDeleteError(objID, err)
+
+			if err == nil {
+				c.auditChange(rgnp.cluster.ClusterID, objID, rgnp.np, nil, trigger)
 			}
 		}
 	}
 }
 
+func logDeleteError(objID string, err error) {
+	if errors.Is(err, remotecluster.ErrConflict) {
+		klog.InfoS("Conflict deleting the generated policy", "policy", objID, "conflict", err.Error())
+	} else if err != nil {
+		klog.ErrorS(err, "Error deleting the generated policy", "policy", objID)
+	}
+}
+
+func logFinalizerError(objID string, err error) {
+	if err != nil {
+		klog.ErrorS(err, "Unable to remove the finalizer", "policy", objID)
+	}
+}
+
 // processGeneratedNetworkPolicyEvent processes events related to NetworkPolicies that we
 // have generated ourselves and that show up on the remote clusters. We should not generate
 // new policies based on those, but we should track them.
@@ -96,9 +255,31 @@ func (c *CoastguardController) processGeneratedNetworkPolicyEvent(event *remotec
 	}
 }
 
+// originatingObjID returns the ObjID of the original policy of a generated policy, which is found
+// by the generated name when the annotation recording it was removed by others.
+func (c *CoastguardController) originatingObjID(cluster *remotecluster.RemoteCluster, np *v1net.NetworkPolicy) string {
+	if objID := networkpolicy.OriginatingObjID(np); objID != "" {
+		return objID
+	}
+
+	for objID, rnp := range c.remoteNetworkPolicies {
+		if rnp.Cluster.ClusterID == cluster.ClusterID && rnp.Np.Namespace == np.Namespace && rnp.GeneratedPolicyName() == np.Name {
+			return objID
+		}
+	}
+
+	return ""
+}
+
 func (c *CoastguardController) addedGeneratedNetworkPolicy(event *remotecluster.Event) {
 	np := event.Objs[0].(*v1net.NetworkPolicy)
-	origObjID := networkpolicy.OriginatingObjID(np)
+
+	origObjID := c.originatingObjID(event.Cluster, np)
+	if origObjID == "" {
+		klog.InfoS("Ignoring a generated policy whose original policy is unknown", "cluster", event.Cluster.ClusterID,
+			"generatedPolicy", klog.KObj(np))
+		return
+	}
 
 	if existingNp, exists := c.remoteGenNetworkPolicies[origObjID]; !exists {
 		c.remoteGenNetworkPolicies[origObjID] = &remoteGeneratedNetworkPolicy{cluster: event.Cluster, np: np}
@@ -109,7 +290,13 @@ func (c *CoastguardController) addedGeneratedNetworkPolicy(event *remotecluster.
 
 func (c *CoastguardController) updatedGeneratedNetworkPolicy(event *remotecluster.Event) {
 	np := event.Objs[1].(*v1net.NetworkPolicy)
-	origObjID := networkpolicy.OriginatingObjID(np)
+
+	origObjID := c.originatingObjID(event.Cluster, np)
+	if origObjID == "" {
+		klog.InfoS("Ignoring a generated policy whose original policy is unknown", "cluster", event.Cluster.ClusterID,
+			"generatedPolicy", klog.KObj(np))
+		return
+	}
 
 	if _, exists := c.remoteGenNetworkPolicies[origObjID]; exists {
 		c.remoteGenNetworkPolicies[origObjID] = &remoteGeneratedNetworkPolicy{cluster: event.Cluster, np: np}
@@ -120,11 +307,12 @@ func (c *CoastguardController) updatedGeneratedNetworkPolicy(event *remotecluste
 
 func (c *CoastguardController) deletedGeneratedNetworkPolicy(event *remotecluster.Event) {
 	np := event.Objs[0].(*v1net.NetworkPolicy)
-	origObjID := networkpolicy.OriginatingObjID(np)
+	origObjID := c.originatingObjID(event.Cluster, np)
 
 	if _, exists := c.remoteGenNetworkPolicies[origObjID]; exists {
 		delete(c.remoteGenNetworkPolicies, origObjID)
 	} else {
-		klog.Warningf("A deleteNetworkPolicy event was received for a np not in our cache: %s", event.ObjID)
+		klog.InfoS("Ignoring the deletion of a generated policy not in our cache", "cluster", event.Cluster.ClusterID,
+			"generatedPolicy", klog.KObj(np))
 	}
 }
@@ -0,0 +1,178 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package externalworkloads
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultClusterID is the ID of the virtual cluster external workloads belong to,
+// when the registry doesn't declare one.
+const DefaultClusterID = "external"
+
+// Registry declares workloads living in the cluster set network, like VMs or bare-metal
+// hosts, which have no pods for us to discover.
+type Registry struct {
+	// ClusterID is the ID of the virtual cluster the workloads belong to
+	ClusterID string `json:"clusterID,omitempty"`
+
+	Workloads []Workload `json:"workloads"`
+}
+
+type Workload struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	IPs       []string          `json:"ips"`
+}
+
+// Load reads and validates a registry YAML file.
+func Load(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading external workloads file %s", path)
+	}
+
+	registry := &Registry{}
+	if err := yaml.UnmarshalStrict(data, registry); err != nil {
+		return nil, errors.Wrapf(err, "error parsing external workloads file %s", path)
+	}
+
+	if registry.ClusterID == "" {
+		registry.ClusterID = DefaultClusterID
+	}
+
+	return registry, registry.validate()
+}
+
+func (r *Registry) validate() error {
+	seen := map[string]bool{}
+
+	for i := range r.Workloads {
+		w := &r.Workloads[i]
+		if w.Name == "" || w.Namespace == "" {
+			return errors.Errorf("external workload %d must have a name and a namespace", i)
+		}
+
+		if seen[w.Namespace+"/"+w.Name] {
+			return errors.Errorf("external workload %s/%s is declared more than once", w.Namespace, w.Name)
+		}
+
+		seen[w.Namespace+"/"+w.Name] = true
+
+		if len(w.IPs) == 0 {
+			return errors.Errorf("external workload %s/%s must have at least one IP", w.Namespace, w.Name)
+		}
+
+		for _, ip := range w.IPs {
+			if net.ParseIP(ip) == nil {
+				return errors.Errorf("external workload %s/%s has an invalid IP %q", w.Namespace, w.Name, ip)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Pods returns the workloads of the registry represented as running pods, so they can be
+// selected by policies exactly like the pods discovered in the remote clusters.
+func (r *Registry) Pods() []*v1.Pod {
+	pods := make([]*v1.Pod, 0, len(r.Workloads))
+
+	for i := range r.Workloads {
+		w := &r.Workloads[i]
+
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: w.Namespace,
+				Name:      w.Name,
+				UID:       types.UID(r.ClusterID + "/" + w.Namespace + "/" + w.Name),
+				Labels:    w.Labels,
+			},
+			Status: v1.PodStatus{
+				Phase:  v1.PodRunning,
+				PodIP:  w.IPs[0],
+				PodIPs: make([]v1.PodIP, 0, len(w.IPs)),
+			},
+		}
+
+		for _, ip := range w.IPs {
+			pod.Status.PodIPs = append(pod.Status.PodIPs, v1.PodIP{IP: ip})
+		}
+
+		pods = append(pods, pod)
+	}
+
+	return pods
+}
+
+// Watch loads the registry file, and reloads it every period when it's modified, calling
+// onChange with each successfully loaded registry. Invalid files are ignored, keeping the
+// previous registry.
+func Watch(path string, period time.Duration, onChange func(*Registry), stopCh <-chan struct{}) {
+	var lastModTime time.Time
+
+	load := func() {
+		info, err := os.Stat(path)
+		if err != nil {
+			klog.ErrorS(err, "Unable to check the external workloads file", "path", path)
+			return
+		}
+
+		if info.ModTime().Equal(lastModTime) {
+			return
+		}
+
+		lastModTime = info.ModTime()
+
+		registry, err := Load(path)
+		if err != nil {
+			klog.ErrorS(err, "Ignoring invalid external workloads file", "path", path)
+			return
+		}
+
+		klog.InfoS("Loaded the external workloads", "path", path, "workloads", len(registry.Workloads))
+		onChange(registry)
+	}
+
+	load()
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				load()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
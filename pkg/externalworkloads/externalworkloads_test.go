@@ -0,0 +1,132 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package externalworkloads_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/externalworkloads"
+)
+
+const validRegistry = `
+clusterID: datacenter
+workloads:
+- name: vm-1
+  namespace: payments
+  labels:
+    app: billing
+  ips: ["10.0.0.5", "fd00::5"]
+`
+
+var _ = Describe("External workloads registry", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "workloads.yaml")
+	})
+
+	writeRegistry := func(content string) {
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	}
+
+	When("loading a valid registry", func() {
+		It("Should represent workloads as running pods", func() {
+			writeRegistry(validRegistry)
+			registry, err := externalworkloads.Load(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(registry.ClusterID).To(Equal("datacenter"))
+
+			pods := registry.Pods()
+			Expect(pods).To(HaveLen(1))
+			Expect(pods[0].Namespace).To(Equal("payments"))
+			Expect(pods[0].Name).To(Equal("vm-1"))
+			Expect(pods[0].Labels).To(HaveKeyWithValue("app", "billing"))
+			Expect(pods[0].Status.PodIP).To(Equal("10.0.0.5"))
+			Expect(pods[0].Status.PodIPs).To(HaveLen(2))
+			Expect(pods[0].UID).ToNot(BeEmpty())
+		})
+
+		It("Should use the default cluster ID when none is declared", func() {
+			writeRegistry("workloads: []")
+			registry, err := externalworkloads.Load(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(registry.ClusterID).To(Equal(externalworkloads.DefaultClusterID))
+		})
+	})
+
+	When("loading an invalid registry", func() {
+		It("Should refuse workloads without IPs", func() {
+			writeRegistry("workloads: [{name: vm-1, namespace: payments}]")
+			_, err := externalworkloads.Load(path)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should refuse invalid IPs", func() {
+			writeRegistry("workloads: [{name: vm-1, namespace: payments, ips: [not-an-ip]}]")
+			_, err := externalworkloads.Load(path)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should refuse duplicated workloads", func() {
+			writeRegistry("workloads: [{name: vm-1, namespace: payments, ips: [10.0.0.1]}, " +
+				"{name: vm-1, namespace: payments, ips: [10.0.0.2]}]")
+			_, err := externalworkloads.Load(path)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should refuse unknown fields", func() {
+			writeRegistry("workloads: [{name: vm-1, namespace: payments, ip: 10.0.0.1}]")
+			_, err := externalworkloads.Load(path)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("watching the registry file", func() {
+		It("Should notify the registry on start and when it's modified", func() {
+			writeRegistry(validRegistry)
+
+			registries := make(chan *externalworkloads.Registry, 10)
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+
+			externalworkloads.Watch(path, 10*time.Millisecond, func(registry *externalworkloads.Registry) {
+				registries <- registry
+			}, stopCh)
+
+			Eventually(registries).Should(Receive())
+
+			writeRegistry("workloads: []")
+			Expect(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))).To(Succeed())
+
+			var registry *externalworkloads.Registry
+			Eventually(registries).Should(Receive(&registry))
+			Expect(registry.Workloads).To(BeEmpty())
+		})
+	})
+})
+
+func TestExternalWorkloads(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: External workloads suite")
+}
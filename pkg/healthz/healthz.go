@@ -20,6 +20,7 @@ package healthz
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"time"
@@ -29,6 +30,18 @@ import (
 
 type Server struct {
 	httpServer *http.Server
+
+	// readinessCheck returns an error when we are not ready, nil means always ready
+	readinessCheck func() error
+
+	// livenessCheck returns an error when we must be restarted, nil means always alive
+	livenessCheck func() error
+
+	// details returns what the verbose responses detail
+	details func() interface{}
+
+	// handlers serve additional paths, like status views
+	handlers map[string]http.Handler
 }
 
 func New(address string) *Server {
@@ -40,6 +53,31 @@ func New(address string) *Server {
 	return healthServer
 }
 
+// SetReadinessCheck sets the check backing /readyz, it must be called before Run.
+func (hs *Server) SetReadinessCheck(check func() error) {
+	hs.readinessCheck = check
+}
+
+// Handle serves path with handler, it must be called before Run.
+func (hs *Server) Handle(path string, handler http.Handler) {
+	if hs.handlers == nil {
+		hs.handlers = map[string]http.Handler{}
+	}
+
+	hs.handlers[path] = handler
+}
+
+// SetLivenessCheck sets the check backing /livez and /healthz, it must be called before Run.
+func (hs *Server) SetLivenessCheck(check func() error) {
+	hs.livenessCheck = check
+}
+
+// SetDetails sets what is detailed, as JSON, by the checks requested with the verbose query parameter,
+// it must be called before Run.
+func (hs *Server) SetDetails(details func() interface{}) {
+	hs.details = details
+}
+
 func (hs *Server) Run(stop <-chan struct{}) {
 	listenAndServeFailed := make(chan struct{})
 
@@ -47,7 +85,7 @@ func (hs *Server) Run(stop <-chan struct{}) {
 		err := hs.httpServer.ListenAndServe()
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			close(listenAndServeFailed)
-			klog.Errorf("Unable to start healthz server: %s", err.Error())
+			klog.ErrorS(err, "Unable to start the healthz server")
 		}
 	}()
 
@@ -66,15 +104,70 @@ func (hs *Server) shutdown() {
 
 	err := hs.httpServer.Shutdown(ctx)
 	if err != nil {
-		klog.Errorf("Error shutting down healthz server: %s", err.Error())
+		klog.ErrorS(err, "Error shutting down the healthz server")
 	}
 }
 
 func (hs *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.EscapedPath() == "/healthz" {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK"))
-	} else {
+	switch r.URL.EscapedPath() {
+	case "/healthz", "/livez":
+		hs.serveCheck(w, r, hs.livenessCheck)
+	case "/readyz":
+		hs.serveCheck(w, r, hs.readinessCheck)
+	default:
+		if handler, exists := hs.handlers[r.URL.EscapedPath()]; exists {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
 		w.WriteHeader(http.StatusNotFound)
 	}
 }
+
+// checkResult is the verbose response of a check.
+type checkResult struct {
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (hs *Server) serveCheck(w http.ResponseWriter, r *http.Request, check func() error) {
+	var err error
+	if check != nil {
+		err = check()
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusServiceUnavailable
+	}
+
+	if !r.URL.Query().Has("verbose") {
+		w.WriteHeader(status)
+
+		if err != nil {
+			_, _ = w.Write([]byte(err.Error()))
+		} else {
+			_, _ = w.Write([]byte("OK"))
+		}
+
+		return
+	}
+
+	result := checkResult{Status: "ok"}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+
+	if hs.details != nil {
+		result.Details = hs.details()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.ErrorS(err, "Error encoding the health check details")
+	}
+}
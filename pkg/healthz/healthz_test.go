@@ -19,6 +19,7 @@ limitations under the License.
 package healthz_test
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -56,6 +57,75 @@ var _ = Describe("Coastguard Healthz server", func() {
 			Expect(resp.Body.String()).To(Equal("OK"))
 		})
 
+		It("It should respond to GET /readyz request when ready", func() {
+			resp := runHealthzRequest("GET", "/readyz")
+
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(resp.Body.String()).To(Equal("OK"))
+		})
+
+		It("It should respond to GET /readyz request when not ready", func() {
+			server := &healthz.Server{}
+			server.SetReadinessCheck(func() error {
+				return errors.New("standing by")
+			})
+
+			req := httptest.NewRequest("GET", "/readyz", http.NoBody)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Body.String()).To(Equal("standing by"))
+		})
+
+		It("It should respond to GET /livez request when the liveness check fails", func() {
+			server := &healthz.Server{}
+			server.SetLivenessCheck(func() error {
+				return errors.New("wedged")
+			})
+
+			for _, path := range []string{"/livez", "/healthz"} {
+				req := httptest.NewRequest("GET", path, http.NoBody)
+				resp := httptest.NewRecorder()
+				server.ServeHTTP(resp, req)
+
+				Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(resp.Body.String()).To(Equal("wedged"))
+			}
+		})
+
+		It("It should detail the checks as JSON when verbose", func() {
+			server := &healthz.Server{}
+			server.SetReadinessCheck(func() error {
+				return errors.New("standing by")
+			})
+			server.SetDetails(func() interface{} {
+				return []string{"cluster1"}
+			})
+
+			req := httptest.NewRequest("GET", "/readyz?verbose", http.NoBody)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(resp.Body.String()).To(MatchJSON(`{"status": "failed", "error": "standing by", "details": ["cluster1"]}`))
+		})
+
+		It("It should respond to GET requests on additional paths", func() {
+			server := &healthz.Server{}
+			server.Handle("/clusters", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("[]"))
+			}))
+
+			req := httptest.NewRequest("GET", "/clusters", http.NoBody)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(resp.Body.String()).To(Equal("[]"))
+		})
+
 		It("It should respond to unexpected GET request", func() {
 			resp := runHealthzRequest("GET", "/unexpected")
 
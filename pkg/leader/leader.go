@@ -0,0 +1,99 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leader
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// Config of the lease used to elect the replica distributing the generated policies.
+type Config struct {
+	// Namespace and Name of the Lease on the hub cluster
+	Namespace string
+	Name      string
+
+	// Identity of this replica, usually the pod name
+	Identity string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// DefaultConfig returns a configuration with the usual Kubernetes controller timings.
+func DefaultConfig(namespace, identity string) Config {
+	return Config{
+		Namespace:     namespace,
+		Name:          "coastguard",
+		Identity:      identity,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+}
+
+// Run campaigns for the lease until stopCh is closed, calling setLeader every time this replica
+// starts or stops leading. Losing the lease doesn't stop the replica, it goes back to standby and
+// campaigns again.
+func Run(clientSet kubernetes.Interface, config Config, setLeader func(bool), stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: config.Namespace, Name: config.Name},
+		Client:     clientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: config.Identity},
+	}
+
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   config.LeaseDuration,
+			RenewDeadline:   config.RenewDeadline,
+			RetryPeriod:     config.RetryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(_ context.Context) {
+					klog.InfoS("Now the leader", "identity", config.Identity)
+					setLeader(true)
+				},
+				OnStoppedLeading: func() {
+					klog.InfoS("No longer the leader", "identity", config.Identity)
+					setLeader(false)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != config.Identity {
+						klog.InfoS("Standing by, another replica is the leader", "leader", identity)
+					}
+				},
+			},
+		})
+	}
+}
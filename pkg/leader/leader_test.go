@@ -0,0 +1,89 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leader_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/leader"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Leader election", func() {
+	var (
+		clientSet *fake.Clientset
+		config    leader.Config
+		stopCh    chan struct{}
+		leading   chan bool
+	)
+
+	BeforeEach(func() {
+		clientSet = fake.NewSimpleClientset()
+		config = leader.Config{
+			Namespace:     "coastguard",
+			Name:          "coastguard",
+			Identity:      "replica-1",
+			LeaseDuration: 2 * time.Second,
+			RenewDeadline: time.Second,
+			RetryPeriod:   100 * time.Millisecond,
+		}
+		stopCh = make(chan struct{})
+		leading = make(chan bool, 10)
+	})
+
+	It("Should lead, then stand by and release the lease when stopped", func() {
+		go leader.Run(clientSet, config, func(isLeader bool) { leading <- isLeader }, stopCh)
+		Eventually(leading, 5).Should(Receive(BeTrue()))
+
+		lease, err := clientSet.CoordinationV1().Leases("coastguard").Get(context.TODO(), "coastguard", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*lease.Spec.HolderIdentity).To(Equal("replica-1"))
+
+		close(stopCh)
+		Eventually(leading, 5).Should(Receive(BeFalse()))
+	})
+
+	It("Should stand by while another replica holds the lease", func() {
+		go leader.Run(clientSet, config, func(isLeader bool) { leading <- isLeader }, stopCh)
+		Eventually(leading, 5).Should(Receive(BeTrue()))
+
+		standbyStopCh := make(chan struct{})
+		defer close(standbyStopCh)
+
+		standbyConfig := config
+		standbyConfig.Identity = "replica-2"
+		standbyLeading := make(chan bool, 10)
+
+		go leader.Run(clientSet, standbyConfig, func(isLeader bool) { standbyLeading <- isLeader }, standbyStopCh)
+		Consistently(standbyLeading, 1).ShouldNot(Receive())
+
+		close(stopCh)
+		Eventually(standbyLeading, 10).Should(Receive(BeTrue()))
+	})
+})
+
+func TestLeader(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: Leader suite")
+}
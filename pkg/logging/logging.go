@@ -0,0 +1,72 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Formats of the logs.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Verbosity levels, the default level only logs what coastguard decides and writes.
+const (
+	// EventLevel logs every cluster event processed, and how it was handled
+	EventLevel = 2
+	// DebugLevel logs the details of the matching of pods by policies
+	DebugLevel = 4
+)
+
+// SetFormat switches the klog output to format, JSON objects are written one per line to out.
+// The klog flags must have been parsed, the verbosity of the JSON logs follows -v.
+func SetFormat(format string, out io.Writer) error {
+	switch format {
+	case FormatText:
+		return nil
+	case FormatJSON:
+		klog.SetLogger(funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(out, obj)
+		}, funcr.Options{LogTimestamp: true, LogCaller: funcr.Error, Verbosity: verbosity()}))
+
+		return nil
+	}
+
+	return errors.Errorf("unknown log format %q, expected %q or %q", format, FormatText, FormatJSON)
+}
+
+// verbosity returns the value of the klog -v flag.
+func verbosity() int {
+	if f := flag.Lookup("v"); f != nil {
+		if v, err := strconv.Atoi(f.Value.String()); err == nil {
+			return v
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,101 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/logging"
+	"k8s.io/klog/v2"
+)
+
+var _ = Describe("Log format", func() {
+	klog.InitFlags(nil)
+
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		DeferCleanup(klog.ClearLogger)
+	})
+
+	lines := func() []map[string]interface{} {
+		klog.Flush()
+
+		objs := []map[string]interface{}{}
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			obj := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(line), &obj)).To(Succeed())
+			objs = append(objs, obj)
+		}
+
+		return objs
+	}
+
+	It("Should write one JSON object per line with the keys of the structured logs", func() {
+		Expect(logging.SetFormat(logging.FormatJSON, out)).To(Succeed())
+
+		klog.InfoS("Distributed the generated policy", "cluster", "cluster1", "policy", "cluster1:default/np1/uid1")
+		klog.ErrorS(errors.New("forbidden"), "Error distributing the generated policy", "policy", "cluster1:default/np1/uid1")
+
+		objs := lines()
+		Expect(objs).To(HaveLen(2))
+		Expect(objs[0]).To(HaveKeyWithValue("msg", "Distributed the generated policy"))
+		Expect(objs[0]).To(HaveKeyWithValue("cluster", "cluster1"))
+		Expect(objs[0]).To(HaveKeyWithValue("policy", "cluster1:default/np1/uid1"))
+		Expect(objs[1]).To(HaveKeyWithValue("error", "forbidden"))
+	})
+
+	It("Should only write the per-event logs at their verbosity", func() {
+		Expect(flag.Set("v", "0")).To(Succeed())
+		Expect(logging.SetFormat(logging.FormatJSON, out)).To(Succeed())
+
+		klog.V(logging.EventLevel).InfoS("Processing event", "pod", "cluster2:default/pod1/uid2")
+		klog.InfoS("A new policy has been generated", "policy", "cluster1:default/np1/uid1")
+
+		objs := lines()
+		Expect(objs).To(HaveLen(1))
+		Expect(objs[0]).To(HaveKeyWithValue("msg", "A new policy has been generated"))
+
+		Expect(flag.Set("v", "2")).To(Succeed())
+		DeferCleanup(flag.Set, "v", "0")
+		out.Reset()
+		Expect(logging.SetFormat(logging.FormatJSON, out)).To(Succeed())
+
+		klog.V(logging.EventLevel).InfoS("Processing event", "pod", "cluster2:default/pod1/uid2")
+		Expect(lines()).To(ConsistOf(HaveKeyWithValue("pod", "cluster2:default/pod1/uid2")))
+	})
+
+	It("Should refuse unknown formats", func() {
+		Expect(logging.SetFormat(logging.FormatText, out)).To(Succeed())
+		Expect(logging.SetFormat("xml", out)).ToNot(Succeed())
+	})
+})
+
+func TestLogging(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: Logging suite")
+}
@@ -0,0 +1,134 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "coastguard"
+
+// Results of the writes to the clusters.
+const (
+	ResultSuccess  = "success"
+	ResultConflict = "conflict"
+	ResultError    = "error"
+)
+
+// Operations on the generated policies.
+const (
+	OperationApply  = "apply"
+	OperationRepair = "repair"
+	OperationDelete = "delete"
+)
+
+// Registry holds every coastguard metric.
+var Registry = prometheus.NewRegistry()
+
+// DriftCorrections counts the generated policies repaired after being modified by others.
+var DriftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "drift_corrections_total",
+	Help:      "Number of generated NetworkPolicies repaired after being modified outside of coastguard.",
+}, []string{"cluster"})
+
+// EventsProcessed counts the cluster events handled by the processing loop.
+var EventsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "events_processed_total",
+	Help:      "Number of cluster events processed, by cluster, object type and event type.",
+}, []string{"cluster", "object_type", "event_type"})
+
+// EventQueueDepth is the number of cluster events waiting to be processed.
+var EventQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "event_queue_depth",
+	Help:      "Number of cluster events waiting to be processed.",
+})
+
+// Writes counts the writes of generated policies to the clusters.
+var Writes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "generated_policy_writes_total",
+	Help:      "Number of writes of generated NetworkPolicies, by cluster, operation and result.",
+}, []string{"cluster", "operation", "result"})
+
+// GeneratedPolicies is the number of generated policies wanted in each cluster.
+var GeneratedPolicies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "generated_policies",
+	Help:      "Number of NetworkPolicies generated for each cluster.",
+}, []string{"cluster"})
+
+// PolicyPeers observes the number of peers of the generated policies written to the clusters.
+var PolicyPeers = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "generated_policy_peers",
+	Help:      "Number of ingress peers of the generated NetworkPolicies written to the clusters.",
+	Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+})
+
+// ClusterSynced is 1 once the caches of a cluster are synced.
+var ClusterSynced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "cluster_synced",
+	Help:      "Whether the caches of each cluster are synced (1) or not (0).",
+}, []string{"cluster"})
+
+// ClusterDegraded is 1 while permissions are missing on a cluster.
+var ClusterDegraded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "cluster_degraded",
+	Help:      "Whether permissions are missing on each cluster (1) or not (0).",
+}, []string{"cluster"})
+
+// PropagationLatency observes the time from a pod change to the write of the generated policies it changed.
+var PropagationLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "propagation_latency_seconds",
+	Help:      "Time from a pod change seen by coastguard to the write of the generated NetworkPolicies it changed.",
+	Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+})
+
+func init() {
+	Registry.MustRegister(DriftCorrections, EventsProcessed, EventQueueDepth, Writes, GeneratedPolicies, PolicyPeers,
+		ClusterSynced, ClusterDegraded, PropagationLatency,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler serves the metrics in the Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RemoveCluster forgets the metrics of a cluster which was removed.
+func RemoveCluster(clusterID string) {
+	labels := prometheus.Labels{"cluster": clusterID}
+
+	for _, vec := range []*prometheus.MetricVec{
+		DriftCorrections.MetricVec, EventsProcessed.MetricVec, Writes.MetricVec, GeneratedPolicies.MetricVec,
+		ClusterSynced.MetricVec, ClusterDegraded.MetricVec,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
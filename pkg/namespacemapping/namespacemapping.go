@@ -0,0 +1,136 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacemapping
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Mapping declares namespace equivalence classes across clusters. Namespaces
+// which belong to the same class are considered the same namespace by the
+// policy selectors, even if their names differ from cluster to cluster.
+// Namespaces which don't belong to any class keep the default namespace
+// sameness behaviour.
+type Mapping struct {
+	// classes maps a "cluster/namespace", or a plain "namespace" for entries
+	// which apply to all clusters, to the name of its equivalence class
+	classes map[string]string
+}
+
+func New() *Mapping {
+	return &Mapping{classes: make(map[string]string)}
+}
+
+// Parse builds a Mapping from the data of a ConfigMap, where every key is the
+// name of an equivalence class, and its value is a list of whitespace or
+// comma separated "clusterID/namespace" entries. An entry without a clusterID
+// applies to that namespace in every cluster, for example:
+//
+//	payments: "cluster-us/payments, cluster-eu/payments-eu"
+func Parse(data map[string]string) (*Mapping, error) {
+	mapping := New()
+
+	// iterate in a stable order so that error reporting is deterministic
+	classNames := make([]string, 0, len(data))
+	for className := range data {
+		classNames = append(classNames, className)
+	}
+
+	sort.Strings(classNames)
+
+	for _, className := range classNames {
+		entries := strings.FieldsFunc(data[className], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+		})
+
+		for _, entry := range entries {
+			clusterID, namespace := splitEntry(entry)
+			if namespace == "" {
+				return nil, errors.Errorf("invalid entry %q in namespace class %q", entry, className)
+			}
+
+			if err := mapping.Add(className, clusterID, namespace); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return mapping, nil
+}
+
+func splitEntry(entry string) (string, string) {
+	if i := strings.Index(entry, "/"); i >= 0 {
+		return entry[:i], entry[i+1:]
+	}
+
+	return "", entry
+}
+
+// Add declares that namespace in clusterID belongs to the class equivalence class,
+// an empty clusterID means the namespace belongs to the class in every cluster.
+func (m *Mapping) Add(class, clusterID, namespace string) error {
+	key := mappingKey(clusterID, namespace)
+
+	if existing, exists := m.classes[key]; exists && existing != class {
+		return errors.Errorf("namespace %q can't belong to both %q and %q namespace classes", key, existing, class)
+	}
+
+	m.classes[key] = class
+
+	return nil
+}
+
+// ClassOf returns the equivalence class of the namespace in clusterID, if any.
+func (m *Mapping) ClassOf(clusterID, namespace string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+
+	if class, exists := m.classes[mappingKey(clusterID, namespace)]; exists {
+		return class, true
+	}
+
+	class, exists := m.classes[namespace]
+
+	return class, exists
+}
+
+// Equivalent returns true if namespaceA in clusterA is to be considered the same
+// namespace as namespaceB in clusterB. A nil Mapping falls back to namespace sameness.
+func (m *Mapping) Equivalent(clusterA, namespaceA, clusterB, namespaceB string) bool {
+	classA, mappedA := m.ClassOf(clusterA, namespaceA)
+	classB, mappedB := m.ClassOf(clusterB, namespaceB)
+
+	if !mappedA && !mappedB {
+		return namespaceA == namespaceB
+	}
+
+	return mappedA && mappedB && classA == classB
+}
+
+func mappingKey(clusterID, namespace string) string {
+	if clusterID == "" {
+		return namespace
+	}
+
+	return clusterID + "/" + namespace
+}
@@ -0,0 +1,106 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacemapping_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/namespacemapping"
+)
+
+const (
+	clusterUS = "cluster-us"
+	clusterEU = "cluster-eu"
+	clusterAP = "cluster-ap"
+)
+
+var _ = Describe("Namespace mapping", func() {
+	When("parsing ConfigMap data", func() {
+		It("Should accept comma and whitespace separated entries", func() {
+			mapping, err := namespacemapping.Parse(map[string]string{
+				"payments": "cluster-us/payments, cluster-eu/payments-eu\ncluster-ap/payments-ap",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			for clusterID, namespace := range map[string]string{
+				clusterUS: "payments", clusterEU: "payments-eu", clusterAP: "payments-ap",
+			} {
+				class, mapped := mapping.ClassOf(clusterID, namespace)
+				Expect(mapped).To(BeTrue())
+				Expect(class).To(Equal("payments"))
+			}
+		})
+
+		It("Should refuse a namespace in two different classes", func() {
+			_, err := namespacemapping.Parse(map[string]string{
+				"payments": "cluster-us/payments",
+				"billing":  "cluster-us/payments",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should refuse entries without a namespace", func() {
+			_, err := namespacemapping.Parse(map[string]string{"payments": "cluster-us/"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("comparing namespaces", func() {
+		var mapping *namespacemapping.Mapping
+
+		BeforeEach(func() {
+			var err error
+			mapping, err = namespacemapping.Parse(map[string]string{
+				"payments": "cluster-us/payments cluster-eu/payments-eu",
+				"shared":   "shared",
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should consider namespaces of the same class equivalent", func() {
+			Expect(mapping.Equivalent(clusterUS, "payments", clusterEU, "payments-eu")).To(BeTrue())
+		})
+
+		It("Should not consider a mapped namespace equivalent to an unmapped one with the same name", func() {
+			Expect(mapping.Equivalent(clusterUS, "payments", clusterAP, "payments")).To(BeFalse())
+		})
+
+		It("Should apply entries without cluster to every cluster", func() {
+			Expect(mapping.Equivalent(clusterUS, "shared", clusterAP, "shared")).To(BeTrue())
+		})
+
+		It("Should fall back to namespace sameness for unmapped namespaces", func() {
+			Expect(mapping.Equivalent(clusterUS, "other", clusterEU, "other")).To(BeTrue())
+			Expect(mapping.Equivalent(clusterUS, "other", clusterEU, "another")).To(BeFalse())
+		})
+
+		It("Should fall back to namespace sameness when there is no mapping", func() {
+			var noMapping *namespacemapping.Mapping
+			Expect(noMapping.Equivalent(clusterUS, "payments", clusterEU, "payments")).To(BeTrue())
+			Expect(noMapping.Equivalent(clusterUS, "payments", clusterEU, "payments-eu")).To(BeFalse())
+		})
+	})
+})
+
+func TestNamespaceMapping(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: Namespace mapping suite")
+}
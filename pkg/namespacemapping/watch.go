@@ -0,0 +1,75 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespacemapping
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const resyncTime = time.Hour * 24
+
+// Watch follows the namespace/name ConfigMap, and calls onChange with the parsed
+// Mapping every time it changes. A missing ConfigMap results in a nil Mapping,
+// and ConfigMaps which fail to parse are ignored, keeping the previous Mapping.
+func Watch(clientSet kubernetes.Interface, namespace, name string, onChange func(*Mapping), stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncTime,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+
+	informer := factory.Core().V1().ConfigMaps().Informer()
+
+	onConfigMap := func(obj interface{}) {
+		configMap, ok := obj.(*v1.ConfigMap)
+		if !ok {
+			return
+		}
+
+		mapping, err := Parse(configMap.Data)
+		if err != nil {
+			klog.ErrorS(err, "Ignoring invalid namespace mapping ConfigMap", "configMap", klog.KRef(namespace, name))
+			return
+		}
+
+		klog.InfoS("Namespace mapping loaded", "configMap", klog.KRef(namespace, name))
+		onChange(mapping)
+	}
+
+	_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onConfigMap,
+		UpdateFunc: func(_, newObj interface{}) {
+			onConfigMap(newObj)
+		},
+		DeleteFunc: func(_ interface{}) {
+			klog.InfoS("Namespace mapping ConfigMap removed, falling back to namespace sameness", "configMap", klog.KRef(namespace, name))
+			onChange(nil)
+		},
+	})
+
+	go informer.Run(stopCh)
+}
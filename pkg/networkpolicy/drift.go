@@ -0,0 +1,90 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkpolicy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1net "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+)
+
+// The hash of the generated content of a generated policy, used to detect changes made by others.
+const coastGuardHashAnnotation = "submariner-io/coastguard-hash"
+
+// generatedContent is everything we generate on a policy, other controllers are free to add
+// their own labels and annotations without this being considered drift.
+type generatedContent struct {
+	Labels      map[string]string       `json:"labels"`
+	Annotations map[string]string       `json:"annotations"`
+	Spec        v1net.NetworkPolicySpec `json:"spec"`
+}
+
+// ContentHash returns the hash of the content coastguard generates on the policy.
+func ContentHash(np *v1net.NetworkPolicy) string {
+	content := generatedContent{
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+		Spec:        np.Spec,
+	}
+
+	for _, key := range []string{coastGuardNameLabel, coastGuardUIDLabel, remotecluster.ManagedByLabel} {
+		if value, exists := np.Labels[key]; exists {
+			content.Labels[key] = value
+		}
+	}
+
+	if value, exists := np.Annotations[coastGuardObjID]; exists {
+		content.Annotations[coastGuardObjID] = value
+	}
+
+	// map keys are sorted by json.Marshal, so the hash is stable
+	data, err := json.Marshal(&content)
+	if err != nil {
+		klog.ErrorS(err, "Unable to hash the NetworkPolicy", "networkPolicy", klog.KObj(np))
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// GeneratedHash returns the content hash recorded on a generated policy when it was generated.
+func GeneratedHash(np *v1net.NetworkPolicy) string {
+	return np.Annotations[coastGuardHashAnnotation]
+}
+
+// HasDrifted returns true if the content of the actual generated policy found in the cluster
+// differs from the expected generated policy.
+func HasDrifted(actual, expected *v1net.NetworkPolicy) bool {
+	return ContentHash(actual) != GeneratedHash(expected)
+}
+
+// IsModifiedByOthers returns true if the actual generated policy has drifted from the expected one,
+// although it carries the expected hash or no hash at all, meaning it's not just an older version
+// of the policy we haven't received the update for yet.
+func IsModifiedByOthers(actual, expected *v1net.NetworkPolicy) bool {
+	actualHash, hashExists := actual.Annotations[coastGuardHashAnnotation]
+
+	return HasDrifted(actual, expected) && (!hashExists || actualHash == GeneratedHash(expected))
+}
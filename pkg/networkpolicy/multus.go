@@ -0,0 +1,120 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkpolicy
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1 "k8s.io/api/core/v1"
+	v1net "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+)
+
+// The Multus annotation where the addresses of every pod interface are reported.
+const multusNetworkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+
+// The Multus secondary networks, whose addresses on the selected pods are also allowed as peers.
+// It's a comma or whitespace separated list of network names, as reported on the network-status
+// annotation, in namespace/name form, or just the name for networks in the pod namespace.
+const coastGuardSecondaryNetworksAnnotation = "submariner-io/coastguard-secondary-networks"
+
+func init() {
+	// the trimmed pods cached by the remote clusters must keep the network status
+	remotecluster.KeepPodAnnotations(multusNetworkStatusAnnotation)
+}
+
+type multusNetworkStatus struct {
+	Name    string   `json:"name"`
+	IPs     []string `json:"ips,omitempty"`
+	Default bool     `json:"default,omitempty"`
+}
+
+func parseSecondaryNetworks(np *v1net.NetworkPolicy) []string {
+	return strings.FieldsFunc(np.Annotations[coastGuardSecondaryNetworksAnnotation], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// ParseSecondaryNetworks parses a comma separated list of clusterID=network entries, where the
+// "*" clusterID includes the network for every cluster.
+func ParseSecondaryNetworks(value string) (map[string][]string, error) {
+	networks := map[string][]string{}
+
+	if value == "" {
+		return networks, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		clusterID, network, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || clusterID == "" || network == "" {
+			return nil, errors.Errorf("invalid secondary network entry %q, expected clusterID=network", entry)
+		}
+
+		networks[clusterID] = append(networks[clusterID], network)
+	}
+
+	return networks, nil
+}
+
+// SecondaryNetworksFor returns the secondary networks configured for clusterID.
+func SecondaryNetworksFor(networks map[string][]string, clusterID string) []string {
+	return slices.Concat(networks["*"], networks[clusterID])
+}
+
+// secondaryNetworkIPs returns the addresses of the pod on the named secondary networks.
+func secondaryNetworkIPs(pod *v1.Pod, networks []string) []string {
+	if len(networks) == 0 {
+		return nil
+	}
+
+	value, exists := pod.Annotations[multusNetworkStatusAnnotation]
+	if !exists {
+		return nil
+	}
+
+	statuses := []multusNetworkStatus{}
+	if err := json.Unmarshal([]byte(value), &statuses); err != nil {
+		klog.ErrorS(err, "Ignoring an invalid Multus network status", "namespace", pod.Namespace, "podName", pod.Name)
+		return nil
+	}
+
+	ips := []string{}
+
+	for i := range statuses {
+		if !statuses[i].Default && isNamedNetwork(statuses[i].Name, pod.Namespace, networks) {
+			ips = append(ips, statuses[i].IPs...)
+		}
+	}
+
+	return ips
+}
+
+func isNamedNetwork(name, podNamespace string, networks []string) bool {
+	for _, network := range networks {
+		if name == network || !strings.Contains(network, "/") && name == podNamespace+"/"+network {
+			return true
+		}
+	}
+
+	return false
+}
@@ -20,9 +20,17 @@ package networkpolicy
 
 import (
 	"fmt"
+	"net"
 	"reflect"
+	"slices"
+	"sort"
 
+	"github.com/submariner-io/coastguard/pkg/audit"
+	"github.com/submariner-io/coastguard/pkg/logging"
+	"github.com/submariner-io/coastguard/pkg/namespacemapping"
 	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	"github.com/submariner-io/coastguard/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
 	v1net "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -41,13 +49,40 @@ type RemoteNetworkPolicy struct {
 	// when changes are detected on such Pod
 	remotePods map[string]*RemotePod
 
+	// remoteEndpointSlices are the EndpointSlices backing the service peers
+	// of this specific policy
+	remoteEndpointSlices map[string]*RemoteEndpointSlice
+
+	// servicePeers are the exported services whose endpoints are allowed as peers
+	servicePeers []servicePeer
+
+	// secondaryNetworks are the Multus networks whose pod addresses are allowed as peers
+	secondaryNetworks []string
+
 	// GeneratedPolicy is the generated network policy for the remote NetworkPolicy
 	// if any policy is generated
 	GeneratedPolicy *v1net.NetworkPolicy
 
+	// GeneratedBy is what changed GeneratedPolicy last, with the span generating it
+	GeneratedBy Cause
+
+	// cause is the event which changed the policy last
+	cause Cause
+
+	// namespaces declares which namespaces are equivalent across clusters,
+	// nil means plain namespace sameness
+	namespaces *namespacemapping.Mapping
+
 	ObjID string
 }
 
+// Cause is what changed a policy: the span its processing continues the trace of, and the trigger
+// its writes are audited with.
+type Cause struct {
+	SpanContext trace.SpanContext
+	Trigger     audit.Trigger
+}
+
 type RemotePod struct {
 	cluster *remotecluster.RemoteCluster
 	Pod     *v1.Pod
@@ -63,23 +98,50 @@ func NewRemotePod(pod *v1.Pod, remoteCluster *remotecluster.RemoteCluster, objID
 }
 
 func NewRemoteNetworkPolicy(np *v1net.NetworkPolicy, remoteCluster *remotecluster.RemoteCluster,
-	objID string, existingPods map[string]*RemotePod,
+	objID string, existingPods map[string]*RemotePod, existingEndpointSlices map[string]*RemoteEndpointSlice,
+	namespaces *namespacemapping.Mapping, event *remotecluster.Event,
 ) *RemoteNetworkPolicy {
 	rnp := &RemoteNetworkPolicy{
-		Cluster:    remoteCluster,
-		Np:         np,
-		remotePods: make(map[string]*RemotePod),
-		ObjID:      objID,
+		Cluster:              remoteCluster,
+		Np:                   np,
+		remotePods:           make(map[string]*RemotePod),
+		remoteEndpointSlices: make(map[string]*RemoteEndpointSlice),
+		servicePeers:         parseServicePeers(np),
+		secondaryNetworks:    parseSecondaryNetworks(np),
+		namespaces:           namespaces,
+		ObjID:                objID,
 	}
 
+	rnp.changedBy(event)
+
 	for _, remotePod := range existingPods {
 		rnp.processAddedPod(remotePod)
 	}
 
+	for _, remoteEps := range existingEndpointSlices {
+		rnp.processEndpointSlice(remoteEps)
+	}
+
 	return rnp
 }
 
+// changedBy remembers the event changing the policy, which a regenerated policy is attributed to,
+// nil when the change isn't caused by an event.
+func (rnp *RemoteNetworkPolicy) changedBy(event *remotecluster.Event) {
+	if event == nil {
+		rnp.cause = Cause{}
+		return
+	}
+
+	rnp.cause = Cause{
+		SpanContext: event.SpanContext,
+		Trigger:     audit.Trigger{Event: string(event.Type), ObjType: string(event.ObjType), ObjID: event.ObjID},
+	}
+}
+
 func (rnp *RemoteNetworkPolicy) AddedPod(event *remotecluster.Event) {
+	rnp.changedBy(event)
+
 	pod := event.Objs[0].(*v1.Pod)
 	remotePod := NewRemotePod(pod, event.Cluster, event.ObjID)
 	rnp.processAddedPod(remotePod)
@@ -91,13 +153,16 @@ func (rnp *RemoteNetworkPolicy) processAddedPod(remotePod *RemotePod) {
 			rnp.addRemotePod(remotePod)
 		}
 	} else {
-		klog.Warningf("Somehow added Pod %s already was tracked by policy %s, updating", remotePod.ObjID, rnp.ObjID)
+		klog.V(logging.EventLevel).InfoS("Updating an added Pod already tracked by the policy", "policy", rnp.ObjID,
+			"pod", remotePod.ObjID)
 		event := remotePod.cluster.NewUpdateEvent(oldPod.Pod, remotePod.Pod)
 		rnp.UpdatedPod(event)
 	}
 }
 
 func (rnp *RemoteNetworkPolicy) UpdatedPod(event *remotecluster.Event) {
+	rnp.changedBy(event)
+
 	if remotePod, exists := rnp.remotePods[event.ObjID]; exists {
 		newPod := event.Objs[1].(*v1.Pod)
 		if !reflect.DeepEqual(remotePod.Pod.ObjectMeta.Labels, newPod.ObjectMeta.Labels) {
@@ -110,16 +175,19 @@ func (rnp *RemoteNetworkPolicy) UpdatedPod(event *remotecluster.Event) {
 		updatedRemotePod := NewRemotePod(newPod, event.Cluster, event.ObjID)
 		rnp.updatedRemotePod(updatedRemotePod)
 	} else {
-		klog.Warningf("Received a Pod update event for a Pod we didn't know about %s", event.ObjID)
+		klog.V(logging.DebugLevel).InfoS("Adding an updated Pod not tracked by the policy", "policy", rnp.ObjID, "pod", event.ObjID)
 		rnp.AddedPod(event.ToAdded())
 	}
 }
 
 func (rnp *RemoteNetworkPolicy) DeletedPod(event *remotecluster.Event) {
+	rnp.changedBy(event)
+
 	if remotePod, exists := rnp.remotePods[event.ObjID]; exists {
 		rnp.removeRemotePod(remotePod)
 	} else {
-		klog.Warningf("Received a Pod delete event for a Pod we didn't know about %s", event.ObjID)
+		klog.V(logging.DebugLevel).InfoS("Ignoring the deletion of a Pod not tracked by the policy", "policy", rnp.ObjID,
+			"pod", event.ObjID)
 	}
 }
 
@@ -131,7 +199,7 @@ func (rnp *RemoteNetworkPolicy) ingressSelectsPod(pod *v1.Pod, remoteCluster *re
 	}
 
 	for i := range rnp.Np.Spec.Ingress {
-		if rnp.ingressRuleSelectsPod(&rnp.Np.Spec.Ingress[i], pod) {
+		if rnp.ingressRuleSelectsPod(&rnp.Np.Spec.Ingress[i], pod, remoteCluster.ClusterID) {
 			return true
 		}
 	}
@@ -139,32 +207,61 @@ func (rnp *RemoteNetworkPolicy) ingressSelectsPod(pod *v1.Pod, remoteCluster *re
 	return false
 }
 
-func (rnp *RemoteNetworkPolicy) ingressRuleSelectsPod(rule *v1net.NetworkPolicyIngressRule, pod *v1.Pod) bool {
+func (rnp *RemoteNetworkPolicy) ingressRuleSelectsPod(rule *v1net.NetworkPolicyIngressRule, pod *v1.Pod, clusterID string) bool {
 	for _, peer := range rule.From {
 		if peer.PodSelector != nil && peer.NamespaceSelector == nil {
-			return rnp.matchesPodSelector(peer.PodSelector, pod)
+			return rnp.matchesPodSelector(peer.PodSelector, pod, clusterID)
 		} else if peer.NamespaceSelector != nil && peer.PodSelector == nil {
 			if len(peer.NamespaceSelector.MatchLabels) == 0 && len(peer.NamespaceSelector.MatchExpressions) == 0 {
 				return true
 			}
 			// TODO: Implement namespace selector
-			klog.Error("Namespace selector still not fully handled")
+			klog.V(logging.DebugLevel).InfoS("Namespace selector still not fully handled", "policy", rnp.ObjID)
 		} else if peer.NamespaceSelector != nil && peer.PodSelector != nil {
 			// TODO: Implement namespace and Pod selector combination
-			klog.Error("Namespace selector + podSelector still not handled")
+			klog.V(logging.DebugLevel).InfoS("Namespace selector + podSelector still not handled", "policy", rnp.ObjID)
 		}
 	}
 
 	return false
 }
 
-func (rnp *RemoteNetworkPolicy) matchesPodSelector(podSelector *metav1.LabelSelector, pod *v1.Pod) bool {
+// UnsupportedPeers describes the ingress peers of the policy which can't select remote pods yet.
+func UnsupportedPeers(np *v1net.NetworkPolicy) []string {
+	unsupported := []string{}
+
+	for i := range np.Spec.Ingress {
+		for j, peer := range np.Spec.Ingress[i].From {
+			var construct string
+
+			switch {
+			case peer.NamespaceSelector != nil && peer.PodSelector != nil:
+				construct = "namespaceSelector with podSelector"
+			case peer.NamespaceSelector != nil &&
+				(len(peer.NamespaceSelector.MatchLabels) > 0 || len(peer.NamespaceSelector.MatchExpressions) > 0):
+				construct = "non-empty namespaceSelector"
+			case peer.PodSelector != nil:
+				if _, err := metav1.LabelSelectorAsSelector(peer.PodSelector); err != nil {
+					construct = "invalid podSelector: " + err.Error()
+				}
+			}
+
+			if construct != "" {
+				unsupported = append(unsupported, fmt.Sprintf("ingress[%d].from[%d]: %s", i, j, construct))
+			}
+		}
+	}
+
+	return unsupported
+}
+
+func (rnp *RemoteNetworkPolicy) matchesPodSelector(podSelector *metav1.LabelSelector, pod *v1.Pod, clusterID string) bool {
 	if len(podSelector.MatchLabels) == 0 && len(podSelector.MatchExpressions) == 0 {
 		// The PodSelector is empty, meaning it selects all pods in this namespace
-		return pod.Namespace == rnp.Np.Namespace
+		return rnp.isPolicyNamespace(pod.Namespace, clusterID)
 	}
 	// Verify if the Pod is in the same namespace as the policy, and then the podselector
-	if pod.Namespace != rnp.Np.Namespace {
+	if !rnp.isPolicyNamespace(pod.Namespace, clusterID) {
 		return false
 	}
 
@@ -172,17 +269,25 @@ func (rnp *RemoteNetworkPolicy) matchesPodSelector(podSelector *metav1.LabelSele
 		return sel.Matches(labels.Set(pod.Labels))
 	}
 
-	klog.Errorf("error validating Np %s PodSelector %v", rnp.ObjID, podSelector)
+	klog.ErrorS(nil, "Invalid PodSelector", "policy", rnp.ObjID, "podSelector", podSelector)
 
 	return false
 }
 
+// isPolicyNamespace returns true if the namespace in clusterID is equivalent to
+// the namespace of the policy, according to the namespace mapping.
+func (rnp *RemoteNetworkPolicy) isPolicyNamespace(namespace, clusterID string) bool {
+	return rnp.namespaces.Equivalent(rnp.Cluster.ClusterID, rnp.Np.Namespace, clusterID, namespace)
+}
+
 func (rnp *RemoteNetworkPolicy) removeRemotePod(remotePod *RemotePod) {
+	klog.V(logging.DebugLevel).InfoS("Policy no longer selects the Pod", "policy", rnp.ObjID, "pod", remotePod.ObjID)
 	delete(rnp.remotePods, remotePod.ObjID)
 	rnp.updateGeneratedPolicy()
 }
 
 func (rnp *RemoteNetworkPolicy) addRemotePod(remotePod *RemotePod) {
+	klog.V(logging.DebugLevel).InfoS("Policy selects the Pod", "policy", rnp.ObjID, "pod", remotePod.ObjID)
 	rnp.remotePods[remotePod.ObjID] = remotePod
 	rnp.updateGeneratedPolicy()
 }
@@ -195,23 +300,92 @@ func (rnp *RemoteNetworkPolicy) updatedRemotePod(remotePod *RemotePod) {
 // The originating network policy ID.
 const coastGuardUIDLabel = "submariner-io/coastguard-Np-uid"
 
+// GeneratedPolicyLabelSelector selects the policies generated by coastguard.
+const GeneratedPolicyLabelSelector = coastGuardUIDLabel
+
 // The name of the originating NetworkPolicy.
 const coastGuardNameLabel = "submariner-io/coastguard-Np"
 
 // The name internal coastguard ID for the originating policy ID.
 const coastGuardObjID = "submariner-io/coastguard-objid"
 
+// IsGenerated returns true if the policy was generated by coastguard, even if some of our
+// labels or annotations were removed from it by others.
 func IsGenerated(np *v1net.NetworkPolicy) bool {
 	_, annotationExists := np.Annotations[coastGuardObjID]
-	return annotationExists
+	_, uidLabelExists := np.Labels[coastGuardUIDLabel]
+
+	return annotationExists || uidLabelExists || np.Labels[remotecluster.ManagedByLabel] == remotecluster.ManagedByValue
 }
 
 func OriginatingObjID(np *v1net.NetworkPolicy) string {
 	return np.Annotations[coastGuardObjID]
 }
 
+// OriginatingUID returns the UID of the policy the generated policy was generated from.
+func OriginatingUID(np *v1net.NetworkPolicy) string {
+	return np.Labels[coastGuardUIDLabel]
+}
+
+// SelectedPods returns the pods selected as peers by the policy, sorted by ObjID.
+func (rnp *RemoteNetworkPolicy) SelectedPods() []*RemotePod {
+	pods := make([]*RemotePod, 0, len(rnp.remotePods))
+	for _, remotePod := range rnp.remotePods {
+		pods = append(pods, remotePod)
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].ObjID < pods[j].ObjID
+	})
+
+	return pods
+}
+
+// SelectedEndpointSlices returns the ObjIDs of the EndpointSlices backing the service peers of the policy, sorted.
+func (rnp *RemoteNetworkPolicy) SelectedEndpointSlices() []string {
+	objIDs := make([]string, 0, len(rnp.remoteEndpointSlices))
+	for objID := range rnp.remoteEndpointSlices {
+		objIDs = append(objIDs, objID)
+	}
+
+	sort.Strings(objIDs)
+
+	return objIDs
+}
+
+// TracksPodsOf returns true if some of the pods the policy tracks belong to the cluster.
+func (rnp *RemoteNetworkPolicy) TracksPodsOf(cluster *remotecluster.RemoteCluster) bool {
+	for _, rp := range rnp.remotePods {
+		if rp.cluster == cluster {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Refresh regenerates the generated policy from the tracked pods, i.e. because their
+// source IPs may have changed, attributing the change to event.
+func (rnp *RemoteNetworkPolicy) Refresh(event *remotecluster.Event) {
+	rnp.changedBy(event)
+	rnp.updateGeneratedPolicy()
+}
+
+// IsBeingDeleted returns true if the original policy is only waiting for its finalizers to be removed.
+func (rnp *RemoteNetworkPolicy) IsBeingDeleted() bool {
+	return rnp.Np.DeletionTimestamp != nil
+}
+
 func (rnp *RemoteNetworkPolicy) updateGeneratedPolicy() {
-	if len(rnp.remotePods) == 0 {
+	_, span := tracing.Start(tracing.Context(rnp.cause.SpanContext), "RemoteNetworkPolicy.updateGeneratedPolicy",
+		tracing.ClusterKey.String(rnp.Cluster.ClusterID), tracing.PolicyKey.String(rnp.ObjID))
+	defer span.End()
+
+	if rnp.IsBeingDeleted() || len(rnp.remotePods) == 0 && len(rnp.remoteEndpointSlices) == 0 {
+		if rnp.GeneratedPolicy != nil {
+			rnp.GeneratedBy = Cause{SpanContext: span.SpanContext(), Trigger: rnp.cause.Trigger}
+		}
+
 		rnp.GeneratedPolicy = nil
 	} else {
 		// make a copy so we maintain the same podSelector, etc...
@@ -223,25 +397,35 @@ func (rnp *RemoteNetworkPolicy) updateGeneratedPolicy() {
 					coastGuardObjID: rnp.ObjID,
 				},
 				Labels: map[string]string{
-					coastGuardNameLabel: rnp.Np.Name,
-					coastGuardUIDLabel:  string(rnp.Np.UID),
+					coastGuardNameLabel:          rnp.Np.Name,
+					coastGuardUIDLabel:           string(rnp.Np.UID),
+					remotecluster.ManagedByLabel: remotecluster.ManagedByValue,
 				},
 			},
 			Spec: v1net.NetworkPolicySpec{
 				PodSelector: rnp.Np.Spec.PodSelector,
 				Ingress:     rnp.generateCIDRIngressRules(rnp.Np.Spec.Ingress),
+				// set explicitly, otherwise the API server defaults it and the content hash never matches
+				PolicyTypes: []v1net.PolicyType{v1net.PolicyTypeIngress},
 			},
 		}
 
+		newPol.Annotations[coastGuardHashAnnotation] = ContentHash(newPol)
+
 		if len(newPol.Spec.Ingress) > 0 {
 			if rnp.GeneratedPolicy != nil && ArePolicyRulesDifferent(rnp.GeneratedPolicy, newPol) ||
 				rnp.GeneratedPolicy == nil {
 				rnp.GeneratedPolicy = newPol
-				klog.Infof("a new policy has been generated for %s", rnp.ObjID)
+				rnp.GeneratedBy = Cause{SpanContext: span.SpanContext(), Trigger: rnp.cause.Trigger}
+				span.AddEvent("generated")
+				klog.InfoS("A new policy has been generated", "cluster", rnp.Cluster.ClusterID, "policy", rnp.ObjID)
 			}
 		} else {
 			if rnp.GeneratedPolicy != nil {
-				klog.Infof("no matching pods on ingress rules for %s, no policy generated anymore", rnp.ObjID)
+				rnp.GeneratedBy = Cause{SpanContext: span.SpanContext(), Trigger: rnp.cause.Trigger}
+				span.AddEvent("withdrawn")
+				klog.InfoS("No matching pods on the ingress rules, no policy generated anymore", "cluster", rnp.Cluster.ClusterID,
+					"policy", rnp.ObjID)
 			}
 			rnp.GeneratedPolicy = nil
 		}
@@ -253,7 +437,8 @@ func (rnp *RemoteNetworkPolicy) generateCIDRIngressRules(ingressRules []v1net.Ne
 
 	for i := range ingressRules {
 		newRule := ingressRules[i].DeepCopy()
-		newRule.From = rnp.buildPodPeersForIngressRule(&ingressRules[i])
+		newRule.From = uniquePeers(append(rnp.buildPodPeersForIngressRule(&ingressRules[i]),
+			rnp.buildServicePeersForIngressRule(i, &ingressRules[i])...))
 
 		if len(newRule.From) > 0 {
 			newIngressRules = append(newIngressRules, *newRule)
@@ -267,15 +452,60 @@ func (rnp *RemoteNetworkPolicy) buildPodPeersForIngressRule(rule *v1net.NetworkP
 	peers := []v1net.NetworkPolicyPeer{}
 
 	for _, rp := range rnp.remotePods {
-		if rnp.ingressRuleSelectsPod(rule, rp.Pod) && rp.Pod.Status.PodIP != "" {
+		if !rnp.ingressRuleSelectsPod(rule, rp.Pod, rp.cluster.ClusterID) {
+			continue
+		}
+
+		// the cluster resolves the address the destination actually sees, which may not be the pod IP
+		ips := rp.cluster.SourceIPs(rp.Pod)
+		ips = append(ips, secondaryNetworkIPs(rp.Pod, slices.Concat(rp.cluster.SecondaryNetworks(), rnp.secondaryNetworks))...)
+
+		for _, ip := range ips {
 			// NOTE: this can be optimized in a future by aggregatting multiple pods over CIDRs
-			peers = append(peers, v1net.NetworkPolicyPeer{IPBlock: &v1net.IPBlock{CIDR: rp.Pod.Status.PodIP + "/32"}})
+			if cidr := hostCIDR(ip); cidr != "" {
+				peers = append(peers, v1net.NetworkPolicyPeer{IPBlock: &v1net.IPBlock{CIDR: cidr}})
+			}
 		}
 	}
 
 	return peers
 }
 
+// uniquePeers removes duplicated ipBlock peers, i.e. a pod which is also a service endpoint,
+// and sorts them so the generated rules are stable.
+func uniquePeers(peers []v1net.NetworkPolicyPeer) []v1net.NetworkPolicyPeer {
+	seen := make(map[string]bool, len(peers))
+	unique := []v1net.NetworkPolicyPeer{}
+
+	for _, peer := range peers {
+		if !seen[peer.IPBlock.CIDR] {
+			seen[peer.IPBlock.CIDR] = true
+			unique = append(unique, peer)
+		}
+	}
+
+	sort.Slice(unique, func(i, j int) bool {
+		return unique[i].IPBlock.CIDR < unique[j].IPBlock.CIDR
+	})
+
+	return unique
+}
+
+// hostCIDR returns the single host CIDR for an IPv4 or IPv6 address, or an empty
+// string if it's not an IP address.
+func hostCIDR(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+
+	if ip.To4() != nil {
+		return address + "/32"
+	}
+
+	return address + "/128"
+}
+
 func generatePolicyName(np *v1net.NetworkPolicy) string {
 	return fmt.Sprintf("coastguard-%s", np.UID)
 }
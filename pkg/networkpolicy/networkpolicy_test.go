@@ -24,13 +24,16 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/namespacemapping"
 	"github.com/submariner-io/coastguard/pkg/remotecluster"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
 )
 
 var _ = Describe("RemoteNetworkPolicies", func() {
@@ -101,6 +104,51 @@ func describeRuleMatching() {
 			Expect(ingressPolicy.Ports).To(HaveLen(1))
 			Expect(ingressPolicy.Ports[0].Port.IntVal).To(BeIdenticalTo(int32(testPort)))
 		})
+
+		It("Should mark the generated policy as managed by coastguard", func() {
+			addAllPods(rnp, clusters, clusterPods)
+			Expect(rnp.GeneratedPolicy.Labels).To(HaveKeyWithValue(remotecluster.ManagedByLabel, remotecluster.ManagedByValue))
+			Expect(IsGenerated(rnp.GeneratedPolicy)).To(BeTrue())
+		})
+
+		It("Should record the hash of the generated content", func() {
+			addAllPods(rnp, clusters, clusterPods)
+			Expect(GeneratedHash(rnp.GeneratedPolicy)).To(Equal(ContentHash(rnp.GeneratedPolicy)))
+		})
+	})
+
+	When("Generated policies are modified by others", func() {
+		var actual *networkingv1.NetworkPolicy
+
+		BeforeEach(func() {
+			addAllPods(rnp, clusters, clusterPods)
+			actual = rnp.GeneratedPolicy.DeepCopy()
+		})
+
+		It("Should ignore labels and annotations of others", func() {
+			actual.Labels["other-controller"] = "true"
+			actual.Annotations["other-controller"] = "true"
+			Expect(HasDrifted(actual, rnp.GeneratedPolicy)).To(BeFalse())
+		})
+
+		It("Should detect spec changes", func() {
+			actual.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{{}}
+			Expect(HasDrifted(actual, rnp.GeneratedPolicy)).To(BeTrue())
+			Expect(IsModifiedByOthers(actual, rnp.GeneratedPolicy)).To(BeTrue())
+		})
+
+		It("Should detect removed coastguard annotations and still consider it generated", func() {
+			delete(actual.Annotations, coastGuardObjID)
+			Expect(IsModifiedByOthers(actual, rnp.GeneratedPolicy)).To(BeTrue())
+			Expect(IsGenerated(actual)).To(BeTrue())
+		})
+
+		It("Should not consider an older generated version as modified by others", func() {
+			actual.Spec.Ingress = nil
+			actual.Annotations[coastGuardHashAnnotation] = ContentHash(actual)
+			Expect(HasDrifted(actual, rnp.GeneratedPolicy)).To(BeTrue())
+			Expect(IsModifiedByOthers(actual, rnp.GeneratedPolicy)).To(BeFalse())
+		})
 	})
 
 	When("Policies have one rule", func() {
@@ -155,6 +203,184 @@ func describeRuleMatching() {
 		})
 	})
 
+	When("Namespaces are mapped across clusters", func() {
+		It("Should select pods from the equivalent namespaces only", func() {
+			mapping, err := namespacemapping.Parse(map[string]string{
+				"tenant": fmt.Sprintf("%s/namespace1 %s/namespace2", clusterID1, clusterID2),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			rnp = NewRemoteNetworkPolicy(rnp.Np, clusters[0], rnp.ObjID, nil, nil, mapping, nil)
+			addAllPods(rnp, clusters, clusterPods)
+
+			By("Verifying only the selected pod of cluster2 namespace2 is on the ingress rule")
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, []string{"2.1.1.2"})
+		})
+	})
+
+	When("Policies allow exported services as peers", func() {
+		BeforeEach(func() {
+			rnp.Np.Annotations = map[string]string{coastGuardServicePeersAnnotation: "0:namespace1/backend"}
+			rnp = NewRemoteNetworkPolicy(rnp.Np, clusters[0], rnp.ObjID, nil, nil, nil, nil)
+		})
+
+		It("Should add the ready endpoints of the service from the other clusters to the ingress rules", func() {
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(newEndpointSlice("backend-1", "namespace1", "backend",
+				"2.9.1.1", "2.9.1.2")))
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(newEndpointSlice("other-1", "namespace1", "other", "2.9.2.1")))
+			rnp.AddedEndpointSlice(clusters[0].NewAddEvent(newEndpointSlice("backend-0", "namespace1", "backend", "1.9.1.1")))
+			notReady := newEndpointSlice("backend-2", "namespace1", "backend", "3.9.1.1")
+			notReady.Endpoints[0].Conditions.Ready = pointer.Bool(false)
+			rnp.AddedEndpointSlice(clusters[2].NewAddEvent(notReady))
+
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, []string{"2.9.1.1", "2.9.1.2"})
+		})
+
+		It("Should follow the service backends", func() {
+			eps := newEndpointSlice("backend-1", "namespace1", "backend", "2.9.1.1")
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(eps))
+
+			updatedEps := newEndpointSlice("backend-1", "namespace1", "backend", "2.9.1.3")
+			rnp.UpdatedEndpointSlice(clusters[1].NewUpdateEvent(eps, updatedEps))
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, []string{"2.9.1.3"})
+
+			rnp.DeletedEndpointSlice(clusters[1].NewDeleteEvent(updatedEps))
+			Expect(rnp.GeneratedPolicy).To(BeNil())
+		})
+
+		It("Should not duplicate pods which are also service endpoints", func() {
+			addAllPods(rnp, clusters, clusterPods)
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(newEndpointSlice("backend-1", "namespace1", "backend", "2.1.1.1")))
+
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, []string{"2.1.1.1", "3.1.1.1"})
+		})
+
+		It("Should ignore the EndpointSlices of services which aren't exported", func() {
+			eps := newEndpointSlice("backend-1", "namespace1", "backend", "2.9.1.1")
+			eps.Labels = map[string]string{discoveryv1.LabelServiceName: "backend"}
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(eps))
+
+			Expect(rnp.GeneratedPolicy).To(BeNil())
+		})
+
+		It("Should not add the endpoints to the ingress rules whose peers are restricted by labels", func() {
+			rnp.Np.Annotations[coastGuardServicePeersAnnotation] = "namespace1/backend"
+			rnp = NewRemoteNetworkPolicy(rnp.Np, clusters[0], rnp.ObjID, nil, nil, nil, nil)
+
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(newEndpointSlice("backend-1", "namespace1", "backend", "2.9.1.1")))
+
+			Expect(rnp.GeneratedPolicy).To(BeNil())
+		})
+
+		It("Should only add the endpoints to the ingress rules selecting every pod of the service namespace, or named", func() {
+			rnp.Np.Annotations[coastGuardServicePeersAnnotation] = "namespace1/backend, namespace2/frontend, 0:namespace2/frontend"
+			rnp.Np.Spec.Ingress = append(rnp.Np.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}},
+			}, networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}}},
+			}, networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+			})
+			rnp = NewRemoteNetworkPolicy(rnp.Np, clusters[0], rnp.ObjID, nil, nil, nil, nil)
+
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(newEndpointSlice("backend-1", "namespace1", "backend", "2.9.1.1")))
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(newEndpointSlice("frontend-1", "namespace2", "frontend", "2.9.2.1")))
+
+			Expect(rnp.GeneratedPolicy.Spec.Ingress).To(HaveLen(3))
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, []string{"2.9.2.1"})
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[1].From, []string{"2.9.1.1", "2.9.2.1"})
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[2].From, []string{"2.9.1.1"})
+		})
+
+		It("Should ignore the service peers of unknown ingress rules", func() {
+			rnp.Np.Annotations[coastGuardServicePeersAnnotation] = "1:namespace1/backend, x:namespace1/backend"
+			rnp = NewRemoteNetworkPolicy(rnp.Np, clusters[0], rnp.ObjID, nil, nil, nil, nil)
+			Expect(rnp.servicePeers).To(BeEmpty())
+		})
+
+		It("Should ignore the endpoints exported from the policy cluster", func() {
+			eps := newEndpointSlice("backend-0", "namespace1", "backend", "1.9.1.1")
+			eps.Labels["multicluster.kubernetes.io/source-cluster"] = clusters[0].ClusterID
+			rnp.AddedEndpointSlice(clusters[1].NewAddEvent(eps))
+
+			Expect(rnp.GeneratedPolicy).To(BeNil())
+		})
+	})
+
+	When("A cluster resolves its pods to other source IPs", func() {
+		It("Should use the resolved source IPs", func() {
+			clusters[1].SetSourceIPResolver(&staticSourceIPResolver{ips: []string{"9.9.9.9"}})
+			addAllPods(rnp, clusters, clusterPods)
+
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, []string{"9.9.9.9", "3.1.1.1"})
+		})
+
+		It("Should only need a refresh for the clusters of its tracked pods", func() {
+			Expect(rnp.TracksPodsOf(clusters[1])).To(BeFalse())
+
+			addAllPods(rnp, clusters, clusterPods)
+			Expect(rnp.TracksPodsOf(clusters[1])).To(BeTrue())
+			Expect(rnp.TracksPodsOf(remotecluster.NewVirtual("other"))).To(BeFalse())
+		})
+	})
+
+	When("Pods have Multus secondary networks", func() {
+		var pod *v1.Pod
+
+		BeforeEach(func() {
+			pod = newPod("multus-pod", testNamespace, testSelectedPods, "2.5.1.1")
+			pod.Annotations = map[string]string{multusNetworkStatusAnnotation: `[
+				{"name": "ovn-kubernetes", "ips": ["2.5.1.1"], "default": true},
+				{"name": "namespace1/macvlan", "ips": ["192.168.10.5"]},
+				{"name": "namespace1/sriov", "ips": ["192.168.20.5", "fd00::5"]}
+			]`}
+		})
+
+		It("Should not include secondary network addresses by default", func() {
+			rnp.AddedPod(clusters[1].NewAddEvent(pod))
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, []string{"2.5.1.1"})
+		})
+
+		It("Should include the addresses of the networks named by the policy", func() {
+			rnp.Np.Annotations = map[string]string{coastGuardSecondaryNetworksAnnotation: "macvlan"}
+			rnp = NewRemoteNetworkPolicy(rnp.Np, clusters[0], rnp.ObjID, nil, nil, nil, nil)
+			rnp.AddedPod(clusters[1].NewAddEvent(pod))
+			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, []string{"2.5.1.1", "192.168.10.5"})
+		})
+
+		It("Should not write to the networks configured for the cluster, which may be shared", func() {
+			networks := make([]string, 1, 4)
+			networks[0] = "namespace1/sriov"
+			clusters[1].SetSecondaryNetworks(networks)
+
+			rnp.Np.Annotations = map[string]string{coastGuardSecondaryNetworksAnnotation: "macvlan"}
+			rnp = NewRemoteNetworkPolicy(rnp.Np, clusters[0], rnp.ObjID, nil, nil, nil, nil)
+			rnp.AddedPod(clusters[1].NewAddEvent(pod))
+
+			Expect(networks[:cap(networks)]).To(Equal([]string{"namespace1/sriov", "", "", ""}))
+		})
+
+		It("Should include the addresses of the networks configured for the cluster", func() {
+			clusters[1].SetSecondaryNetworks([]string{"namespace1/sriov"})
+			rnp.AddedPod(clusters[1].NewAddEvent(pod))
+
+			peers := getCIDRsFromPeers(rnp.GeneratedPolicy.Spec.Ingress[0].From)
+			Expect(peers).To(ConsistOf("2.5.1.1/32", "192.168.20.5/32", "fd00::5/128"))
+		})
+	})
+
+	When("Parsing the secondary networks of each cluster", func() {
+		It("Should merge the networks of all clusters with the cluster ones", func() {
+			networks, err := ParseSecondaryNetworks("*=default/storage, cluster-2=default/macvlan")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(SecondaryNetworksFor(networks, clusterID2)).To(Equal([]string{"default/storage", "default/macvlan"}))
+			Expect(SecondaryNetworksFor(networks, clusterID3)).To(Equal([]string{"default/storage"}))
+
+			_, err = ParseSecondaryNetworks("cluster-2")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	When("Ingress rules have no matching pods", func() {
 		It("Should not generate policies", func() {
 			rnp.Np.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{{
@@ -198,6 +424,22 @@ func describeRuleMatching() {
 			CheckCIDRs(rnp.GeneratedPolicy.Spec.Ingress[0].From, ips)
 		})
 	})
+
+	When("Ingress rules have peers which can't select remote pods yet", func() {
+		It("Should describe them", func() {
+			selector := &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}}
+			rnp.Np.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{
+				{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}, {NamespaceSelector: selector}}},
+				{From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: selector, PodSelector: selector}}},
+				{From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}}},
+			}
+
+			Expect(UnsupportedPeers(rnp.Np)).To(Equal([]string{
+				"ingress[0].from[1]: non-empty namespaceSelector",
+				"ingress[1].from[0]: namespaceSelector with podSelector",
+			}))
+		})
+	})
 }
 
 func collectPodIPs(clusterPods [][]*v1.Pod) []string {
@@ -327,7 +569,7 @@ func createRemotePolicyAndCluster(selectedPods, ingressPods, namespace,
 ) (*RemoteNetworkPolicy, *remotecluster.RemoteCluster) {
 	np := createPodSelectorNetworkPolicy(selectedPods, ingressPods, namespace)
 	rc1 := remotecluster.New(clusterID, fake.NewSimpleClientset())
-	rp := NewRemoteNetworkPolicy(np, rc1, remotecluster.ObjID(np.Namespace, np.Name, rc1.ClusterID, np.UID), nil)
+	rp := NewRemoteNetworkPolicy(np, rc1, remotecluster.ObjID(np.Namespace, np.Name, rc1.ClusterID, np.UID), nil, nil, nil, nil)
 
 	return rp, rc1
 }
@@ -459,6 +701,23 @@ func addAllPods(policy *RemoteNetworkPolicy, clusters []*remotecluster.RemoteClu
 	}
 }
 
+func newEndpointSlice(name, namespace, service string, ips ...string) *discoveryv1.EndpointSlice {
+	eps := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{remotecluster.LabelMultiClusterServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+
+	for _, ip := range ips {
+		eps.Endpoints = append(eps.Endpoints, discoveryv1.Endpoint{Addresses: []string{ip}})
+	}
+
+	return eps
+}
+
 func newPod(name, namespace, podLabel, ip string) *v1.Pod {
 	return &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
@@ -478,6 +737,16 @@ func newPod(name, namespace, podLabel, ip string) *v1.Pod {
 	}
 }
 
+type staticSourceIPResolver struct {
+	ips []string
+}
+
+func (r *staticSourceIPResolver) SourceIPs(_ *v1.Pod) []string {
+	return r.ips
+}
+
+func (r *staticSourceIPResolver) Run(_ func(), _ <-chan struct{}) {}
+
 func TestNetworkPolicies(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Coastguard: RemoteNetworkPolicy suite")
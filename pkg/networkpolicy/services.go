@@ -0,0 +1,236 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkpolicy
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	v1net "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// The exported services, whose endpoints in any other cluster of the cluster set are allowed
+// as ingress peers. It's a comma or whitespace separated list of namespace/service entries, allowed
+// on the ingress rules with a peer selecting every pod of the service namespace, or of
+// index:namespace/service entries, allowed on the ingress rule at that index.
+const coastGuardServicePeersAnnotation = "submariner-io/coastguard-service-peers"
+
+// The cluster exporting the endpoints of an EndpointSlice, per the multicluster services API.
+const labelMultiClusterSourceCluster = "multicluster.kubernetes.io/source-cluster"
+
+// anyIngressRule is the rule of the service peers allowed on the ingress rules selecting every pod of
+// the service namespace.
+const anyIngressRule = -1
+
+type servicePeer struct {
+	namespace string
+	name      string
+	// rule is the index of the ingress rule the service is allowed on, or anyIngressRule
+	rule int
+}
+
+// RemoteEndpointSlice is an EndpointSlice backing a service in one of the remote clusters.
+type RemoteEndpointSlice struct {
+	cluster       *remotecluster.RemoteCluster
+	EndpointSlice *discoveryv1.EndpointSlice
+	ObjID         string
+}
+
+func NewRemoteEndpointSlice(eps *discoveryv1.EndpointSlice, remoteCluster *remotecluster.RemoteCluster,
+	objID string,
+) *RemoteEndpointSlice {
+	return &RemoteEndpointSlice{
+		cluster:       remoteCluster,
+		EndpointSlice: eps,
+		ObjID:         objID,
+	}
+}
+
+func parseServicePeers(np *v1net.NetworkPolicy) []servicePeer {
+	value, exists := np.Annotations[coastGuardServicePeersAnnotation]
+	if !exists {
+		return nil
+	}
+
+	peers := []servicePeer{}
+
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		rule := anyIngressRule
+		service := entry
+
+		if index, peer, found := strings.Cut(entry, ":"); found {
+			parsed, err := strconv.Atoi(index)
+			if err != nil || parsed < 0 || parsed >= len(np.Spec.Ingress) {
+				klog.ErrorS(err, "Ignoring a service peer of an unknown ingress rule", "networkPolicy", klog.KObj(np),
+					"servicePeer", entry)
+
+				continue
+			}
+
+			rule, service = parsed, peer
+		}
+
+		namespace, name, found := strings.Cut(service, "/")
+		if !found || namespace == "" || name == "" {
+			klog.ErrorS(nil, "Ignoring an invalid service peer, expected [index:]namespace/service", "networkPolicy", klog.KObj(np),
+				"servicePeer", entry)
+
+			continue
+		}
+
+		peers = append(peers, servicePeer{namespace: namespace, name: name, rule: rule})
+	}
+
+	return peers
+}
+
+func (rnp *RemoteNetworkPolicy) AddedEndpointSlice(event *remotecluster.Event) {
+	rnp.changedBy(event)
+
+	eps := event.Objs[0].(*discoveryv1.EndpointSlice)
+	rnp.processEndpointSlice(NewRemoteEndpointSlice(eps, event.Cluster, event.ObjID))
+}
+
+func (rnp *RemoteNetworkPolicy) UpdatedEndpointSlice(event *remotecluster.Event) {
+	rnp.changedBy(event)
+
+	eps := event.Objs[1].(*discoveryv1.EndpointSlice)
+	rnp.processEndpointSlice(NewRemoteEndpointSlice(eps, event.Cluster, event.ObjID))
+}
+
+func (rnp *RemoteNetworkPolicy) DeletedEndpointSlice(event *remotecluster.Event) {
+	rnp.changedBy(event)
+
+	if _, exists := rnp.remoteEndpointSlices[event.ObjID]; exists {
+		delete(rnp.remoteEndpointSlices, event.ObjID)
+		rnp.updateGeneratedPolicy()
+	}
+}
+
+func (rnp *RemoteNetworkPolicy) processEndpointSlice(remoteEps *RemoteEndpointSlice) {
+	_, tracked := rnp.remoteEndpointSlices[remoteEps.ObjID]
+
+	if rnp.selectsEndpointSlice(remoteEps) {
+		rnp.remoteEndpointSlices[remoteEps.ObjID] = remoteEps
+		rnp.updateGeneratedPolicy()
+	} else if tracked {
+		delete(rnp.remoteEndpointSlices, remoteEps.ObjID)
+		rnp.updateGeneratedPolicy()
+	}
+}
+
+// selectsEndpointSlice returns true if the EndpointSlice backs one of the service peers of the policy,
+// only the EndpointSlices of exported services are considered.
+func (rnp *RemoteNetworkPolicy) selectsEndpointSlice(remoteEps *RemoteEndpointSlice) bool {
+	// never select endpoints from it's own cluster, it's not our business
+	if rnp.Cluster.ClusterID == remoteEps.cluster.ClusterID ||
+		rnp.Cluster.ClusterID == remoteEps.EndpointSlice.Labels[labelMultiClusterSourceCluster] {
+		return false
+	}
+
+	for _, peer := range rnp.servicePeers {
+		if rnp.isServicePeer(peer, remoteEps) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isServicePeer returns true if the EndpointSlice backs the exported service of the peer.
+func (rnp *RemoteNetworkPolicy) isServicePeer(peer servicePeer, remoteEps *RemoteEndpointSlice) bool {
+	serviceName, exported := remoteEps.EndpointSlice.Labels[remotecluster.LabelMultiClusterServiceName]
+
+	return exported && peer.name == serviceName && rnp.namespaces.Equivalent(rnp.Cluster.ClusterID, peer.namespace,
+		remoteEps.cluster.ClusterID, remoteEps.EndpointSlice.Namespace)
+}
+
+// ingressRuleSelectsNamespace returns true if a peer of the rule selects every pod in the namespace of clusterID:
+// an empty podSelector peer in the namespace of the policy, or an empty namespaceSelector peer without podSelector
+// or with an empty one. Peers restricted by labels don't select the service endpoints, which may be any pod.
+func (rnp *RemoteNetworkPolicy) ingressRuleSelectsNamespace(rule *v1net.NetworkPolicyIngressRule, namespace, clusterID string) bool {
+	for _, peer := range rule.From {
+		if peer.PodSelector != nil && !isEmptySelector(peer.PodSelector) {
+			continue
+		}
+
+		if peer.PodSelector != nil && peer.NamespaceSelector == nil && rnp.isPolicyNamespace(namespace, clusterID) {
+			return true
+		} else if peer.NamespaceSelector != nil && isEmptySelector(peer.NamespaceSelector) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+// allowsEndpointSlice returns true if one of the service peers backed by the EndpointSlice is allowed on the
+// ingress rule at index.
+func (rnp *RemoteNetworkPolicy) allowsEndpointSlice(index int, rule *v1net.NetworkPolicyIngressRule,
+	remoteEps *RemoteEndpointSlice,
+) bool {
+	for _, peer := range rnp.servicePeers {
+		if !rnp.isServicePeer(peer, remoteEps) {
+			continue
+		}
+
+		if peer.rule == index || (peer.rule == anyIngressRule &&
+			rnp.ingressRuleSelectsNamespace(rule, remoteEps.EndpointSlice.Namespace, remoteEps.cluster.ClusterID)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rnp *RemoteNetworkPolicy) buildServicePeersForIngressRule(index int, rule *v1net.NetworkPolicyIngressRule,
+) []v1net.NetworkPolicyPeer {
+	peers := []v1net.NetworkPolicyPeer{}
+
+	for _, remoteEps := range rnp.remoteEndpointSlices {
+		if !rnp.allowsEndpointSlice(index, rule, remoteEps) {
+			continue
+		}
+
+		for i := range remoteEps.EndpointSlice.Endpoints {
+			endpoint := &remoteEps.EndpointSlice.Endpoints[i]
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+
+			for _, address := range endpoint.Addresses {
+				if cidr := hostCIDR(address); cidr != "" {
+					peers = append(peers, v1net.NetworkPolicyPeer{IPBlock: &v1net.IPBlock{CIDR: cidr}})
+				}
+			}
+		}
+	}
+
+	return peers
+}
@@ -0,0 +1,107 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkpolicy
+
+import (
+	"encoding/json"
+	"reflect"
+
+	v1net "k8s.io/api/networking/v1"
+)
+
+// StatusAnnotation summarizes the cross-cluster effect of an original policy, as a JSON Status.
+const StatusAnnotation = "submariner-io/coastguard-status"
+
+// Status is the cross-cluster effect of an original policy.
+type Status struct {
+	// GeneratedPolicies are the names of the policies generated from the original policy
+	GeneratedPolicies []string `json:"generatedPolicies,omitempty"`
+
+	// Peers are the numbers of pods and EndpointSlices selected as peers in each source cluster
+	Peers map[string]int `json:"peers,omitempty"`
+
+	// UnsupportedPeers describes the peers skipped as they can't select remote pods yet
+	UnsupportedPeers []string `json:"unsupportedPeers,omitempty"`
+
+	// LastSyncTime is when the status last changed, in RFC 3339 format
+	LastSyncTime string `json:"lastSyncTime,omitempty"`
+}
+
+// Status returns the cross-cluster effect of the policy, with no LastSyncTime.
+func (rnp *RemoteNetworkPolicy) Status() *Status {
+	status := &Status{Peers: map[string]int{}, UnsupportedPeers: UnsupportedPeers(rnp.Np)}
+
+	if rnp.GeneratedPolicy != nil {
+		status.GeneratedPolicies = []string{rnp.GeneratedPolicy.Name}
+	}
+
+	for _, remotePod := range rnp.remotePods {
+		status.Peers[remotePod.cluster.ClusterID]++
+	}
+
+	for _, remoteEps := range rnp.remoteEndpointSlices {
+		status.Peers[remoteEps.cluster.ClusterID]++
+	}
+
+	return status
+}
+
+// IsEmpty returns true if the policy has no cross-cluster effect to report.
+func (s *Status) IsEmpty() bool {
+	return len(s.GeneratedPolicies) == 0 && len(s.Peers) == 0 && len(s.UnsupportedPeers) == 0
+}
+
+// SameAs returns true if both statuses report the same effect, whenever they were synced.
+func (s *Status) SameAs(other *Status) bool {
+	return other != nil && reflect.DeepEqual(s.effect(), other.effect())
+}
+
+// effect returns the status without its sync time, with empty fields normalized.
+func (s *Status) effect() Status {
+	effect := Status{}
+
+	if len(s.GeneratedPolicies) > 0 {
+		effect.GeneratedPolicies = s.GeneratedPolicies
+	}
+
+	if len(s.Peers) > 0 {
+		effect.Peers = s.Peers
+	}
+
+	if len(s.UnsupportedPeers) > 0 {
+		effect.UnsupportedPeers = s.UnsupportedPeers
+	}
+
+	return effect
+}
+
+// CurrentStatus returns the status annotated on the original policy, nil if there's none, or it can't be parsed.
+func CurrentStatus(np *v1net.NetworkPolicy) *Status {
+	value, exists := np.Annotations[StatusAnnotation]
+	if !exists {
+		return nil
+	}
+
+	status := &Status{}
+	if err := json.Unmarshal([]byte(value), status); err != nil {
+		return nil
+	}
+
+	return status
+}
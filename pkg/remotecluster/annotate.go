@@ -0,0 +1,53 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	v1net "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Annotate sets an annotation on an original NetworkPolicy, leaving the rest of it alone. It's not an error
+// if the policy is gone, or was replaced by another one with the same name.
+func (rc *RemoteCluster) Annotate(np *v1net.NetworkPolicy, key, value string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			// the UID is a precondition, so a replaced policy isn't annotated
+			"uid":         np.UID,
+			"annotations": map[string]string{key: value},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "error marshalling the annotation patch")
+	}
+
+	_, err = rc.ClientSet.NetworkingV1().NetworkPolicies(np.Namespace).Patch(context.TODO(), np.Name, types.MergePatchType, patch,
+		v1.PatchOptions{FieldManager: StatusFieldManager})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+
+	return errors.Wrapf(err, "error annotating NetworkPolicy %s/%s in cluster %s", np.Namespace, np.Name, rc.ClusterID)
+}
@@ -20,32 +20,196 @@ package remotecluster
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/tracing"
 	v1net "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	networkingv1ac "k8s.io/client-go/applyconfigurations/networking/v1"
+	"k8s.io/klog/v2"
 )
 
-func (rc *RemoteCluster) Distribute(np *v1net.NetworkPolicy) error {
+// The label marking the NetworkPolicies generated, and therefore managed, by coastguard.
+const (
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "coastguard"
+)
+
+// FieldManager is the server-side apply field manager owning the fields of the generated policies.
+const FieldManager = "coastguard"
+
+// StatusFieldManager is the field manager of the annotations set on original policies, which are not ours, so
+// they must not look like they're managed by coastguard.
+const StatusFieldManager = "coastguard-status"
+
+// The prefix of the labels and annotations coastguard sets on the generated NetworkPolicies.
+const coastGuardKeyPrefix = "submariner-io/coastguard"
+
+// The original policy UID label and ID annotation, which the generated NetworkPolicies always had.
+const (
+	originatingUIDLabel        = coastGuardKeyPrefix + "-Np-uid"
+	originatingObjIDAnnotation = coastGuardKeyPrefix + "-objid"
+)
+
+// ErrConflict is returned when we refuse to touch an object which is not managed by coastguard.
+var ErrConflict = errors.New("conflict with an object not managed by coastguard")
+
+// IsOwned returns true if the existing NetworkPolicy is managed by coastguard, and none of its coastguard
+// labels and annotations differ from those of np, meaning both were generated from the same original policy.
+// Keys missing on the existing policy, i.e. removed by someone else, don't prevent us from repairing it.
+func IsOwned(existing, np *v1net.NetworkPolicy) bool {
+	if !isManagedByCoastGuard(existing) && !isLegacyGenerated(existing, np) {
+		return false
+	}
+
+	return coastGuardKeysMatch(existing.Labels, np.Labels) && coastGuardKeysMatch(existing.Annotations, np.Annotations)
+}
+
+// isManagedByCoastGuard checks the managed-by label, or the server-side apply managed fields,
+// in case the label was removed.
+func isManagedByCoastGuard(np *v1net.NetworkPolicy) bool {
+	if np.Labels[ManagedByLabel] == ManagedByValue {
+		return true
+	}
+
+	for i := range np.ManagedFields {
+		if np.ManagedFields[i].Manager == FieldManager {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isLegacyGenerated recognizes the policies generated by former versions, which had neither the managed-by
+// label nor our field manager, from their original policy UID label and ID annotation, which must be those of np.
+// They're adopted, the next apply or repair adds the managed-by label.
+func isLegacyGenerated(existing, np *v1net.NetworkPolicy) bool {
+	uid, objID := np.Labels[originatingUIDLabel], np.Annotations[originatingObjIDAnnotation]
+
+	return uid != "" && objID != "" && existing.Labels[originatingUIDLabel] == uid &&
+		existing.Annotations[originatingObjIDAnnotation] == objID
+}
+
+func coastGuardKeysMatch(existing, expected map[string]string) bool {
+	for key, value := range expected {
+		if !strings.HasPrefix(key, coastGuardKeyPrefix) {
+			continue
+		}
+
+		if existingValue, exists := existing[key]; exists && existingValue != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Distribute server-side applies the generated NetworkPolicy to the cluster. Only the fields set on np
+// are owned by coastguard, so fields added by other controllers are preserved, and conflicts over the
+// fields we generate are reported, then forced.
+func (rc *RemoteCluster) Distribute(ctx context.Context, np *v1net.NetworkPolicy) (err error) {
+	ctx, span := tracing.Start(ctx, "RemoteCluster.Distribute", tracing.ClusterKey.String(rc.ClusterID),
+		tracing.NamespaceKey.String(np.Namespace), tracing.NameKey.String(np.Name))
+	defer func() { tracing.End(span, err) }()
+
 	npClient := rc.ClientSet.NetworkingV1().NetworkPolicies(np.Namespace)
 
-	_, err := npClient.Update(context.TODO(), np, v1.UpdateOptions{})
+	existing, err := npClient.Get(ctx, np.Name, v1.GetOptions{})
+	if err == nil && !IsOwned(existing, np) {
+		return errors.Wrapf(ErrConflict, "refusing to update NetworkPolicy %s/%s in cluster %s", np.Namespace, np.Name, rc.ClusterID)
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "error getting NetworkPolicy %s for cluster %s", np.Name, rc.ClusterID)
+	}
 
-	if err == nil {
-		return nil
-	} else if !apierrors.IsNotFound(err) {
-		return errors.Wrapf(err, "error updating NetworkPolicy %s for cluster %s", np.Name, rc.ClusterID)
+	applyConfig, err := toApplyConfiguration(np)
+	if err != nil {
+		return err
 	}
 
-	_, err = npClient.Create(context.TODO(), np, v1.CreateOptions{})
+	_, err = npClient.Apply(ctx, applyConfig, v1.ApplyOptions{FieldManager: FieldManager})
+	if apierrors.IsConflict(err) {
+		klog.InfoS("Fields of the generated NetworkPolicy are managed by others, forcing ownership", "cluster", rc.ClusterID,
+			"generatedPolicy", klog.KObj(np), "conflict", err.Error())
 
-	return errors.Wrapf(err, "error creating NetworkPolicy %s for cluster %s", np.Name, rc.ClusterID)
+		_, err = npClient.Apply(ctx, applyConfig, v1.ApplyOptions{FieldManager: FieldManager, Force: true})
+	}
+
+	return errors.Wrapf(err, "error applying NetworkPolicy %s for cluster %s", np.Name, rc.ClusterID)
+}
+
+// toApplyConfiguration converts the generated NetworkPolicy into an apply configuration holding
+// exactly the fields we generate.
+func toApplyConfiguration(np *v1net.NetworkPolicy) (*networkingv1ac.NetworkPolicyApplyConfiguration, error) {
+	data, err := json.Marshal(np)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshaling NetworkPolicy %s", np.Name)
+	}
+
+	applyConfig := networkingv1ac.NetworkPolicy(np.Name, np.Namespace)
+	if err := json.Unmarshal(data, applyConfig); err != nil {
+		return nil, errors.Wrapf(err, "error building the apply configuration of NetworkPolicy %s", np.Name)
+	}
+
+	return applyConfig, nil
+}
+
+// Repair updates the generated NetworkPolicy modified by others back to its generated content. Unlike
+// Distribute, the whole spec is replaced, so fields added by others, like egress rules, are removed, while
+// their labels and annotations are preserved.
+func (rc *RemoteCluster) Repair(np *v1net.NetworkPolicy) error {
+	npClient := rc.ClientSet.NetworkingV1().NetworkPolicies(np.Namespace)
+
+	existing, err := npClient.Get(context.TODO(), np.Name, v1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error getting NetworkPolicy %s from cluster %s", np.Name, rc.ClusterID)
+	}
+
+	if !IsOwned(existing, np) {
+		return errors.Wrapf(ErrConflict, "refusing to repair NetworkPolicy %s/%s in cluster %s", np.Namespace, np.Name, rc.ClusterID)
+	}
+
+	repaired := existing.DeepCopy()
+	repaired.Labels = mergeKeys(repaired.Labels, np.Labels)
+	repaired.Annotations = mergeKeys(repaired.Annotations, np.Annotations)
+	repaired.Spec = *np.Spec.DeepCopy()
+
+	// the resource version of existing makes sure we don't overwrite changes we haven't checked
+	_, err = npClient.Update(context.TODO(), repaired, v1.UpdateOptions{FieldManager: FieldManager})
+
+	return errors.Wrapf(err, "error repairing NetworkPolicy %s in cluster %s", np.Name, rc.ClusterID)
+}
+
+func mergeKeys(existing, generated map[string]string) map[string]string {
+	if existing == nil {
+		existing = map[string]string{}
+	}
+
+	for key, value := range generated {
+		existing[key] = value
+	}
+
+	return existing
 }
 
-func (rc *RemoteCluster) Delete(np *v1net.NetworkPolicy) error {
+func (rc *RemoteCluster) Delete(ctx context.Context, np *v1net.NetworkPolicy) error {
 	npClient := rc.ClientSet.NetworkingV1().NetworkPolicies(np.Namespace)
 
-	return errors.Wrapf(npClient.Delete(context.TODO(), np.Name, v1.DeleteOptions{}),
+	existing, err := npClient.Get(ctx, np.Name, v1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error getting NetworkPolicy %s from cluster %s", np.Name, rc.ClusterID)
+	}
+
+	if !IsOwned(existing, np) {
+		return errors.Wrapf(ErrConflict, "refusing to delete NetworkPolicy %s/%s from cluster %s", np.Namespace, np.Name, rc.ClusterID)
+	}
+
+	// make sure we delete the very object we checked
+	preconditions := v1.Preconditions{UID: &existing.UID, ResourceVersion: &existing.ResourceVersion}
+
+	return errors.Wrapf(npClient.Delete(ctx, np.Name, v1.DeleteOptions{Preconditions: &preconditions}),
 		"error deleting NetworkPolicy %s from cluster %s", np.Name, rc.ClusterID)
 }
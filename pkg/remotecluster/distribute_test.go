@@ -0,0 +1,321 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1net "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testGeneratedPolicyName = "coastguard-" + testUID
+	testObjIDAnnotation     = "submariner-io/coastguard-objid"
+	testUIDLabel            = "submariner-io/coastguard-Np-uid"
+)
+
+var _ = Describe("Distribution of generated policies", func() {
+	var (
+		remoteCluster *RemoteCluster
+		existing      []runtime.Object
+		np            *v1net.NetworkPolicy
+	)
+
+	BeforeEach(func() {
+		existing = nil
+		np = newGeneratedPolicy("cluster-2:default/np1/uid")
+	})
+
+	JustBeforeEach(func() {
+		remoteCluster = New(clusterID1, newClientSetWithApply(existing...))
+	})
+
+	getPolicy := func() (*v1net.NetworkPolicy, error) {
+		return remoteCluster.ClientSet.NetworkingV1().NetworkPolicies(testNamespace).Get(context.TODO(),
+			testGeneratedPolicyName, metav1.GetOptions{})
+	}
+
+	When("the generated policy doesn't exist", func() {
+		It("Should create it with server-side apply", func() {
+			Expect(remoteCluster.Distribute(context.TODO(), np)).To(Succeed())
+			created, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created.Annotations).To(HaveKeyWithValue(testObjIDAnnotation, "cluster-2:default/np1/uid"))
+			Expect(patchActions(remoteCluster.ClientSet.(*fake.Clientset))).To(Equal(1))
+		})
+	})
+
+	When("the generated policy exists and is managed by coastguard", func() {
+		BeforeEach(func() {
+			existing = []runtime.Object{newGeneratedPolicy("cluster-2:default/np1/uid")}
+		})
+
+		It("Should update it", func() {
+			np.Spec.PolicyTypes = []v1net.PolicyType{v1net.PolicyTypeIngress}
+			Expect(remoteCluster.Distribute(context.TODO(), np)).To(Succeed())
+			updated, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Spec.PolicyTypes).To(HaveLen(1))
+		})
+
+		It("Should preserve the fields set by others", func() {
+			existing, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+			existing.Labels["other-controller"] = "true"
+			_, err = remoteCluster.ClientSet.NetworkingV1().NetworkPolicies(testNamespace).Update(context.TODO(), existing,
+				metav1.UpdateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(remoteCluster.Distribute(context.TODO(), np)).To(Succeed())
+			updated, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Labels).To(HaveKey("other-controller"))
+		})
+
+		It("Should force the ownership of the generated fields on conflicts", func() {
+			clientSet := remoteCluster.ClientSet.(*fake.Clientset)
+			conflicts := 0
+			clientSet.PrependReactor("patch", "networkpolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if conflicts == 0 {
+					conflicts++
+					return true, nil, apierrors.NewConflict(v1net.Resource("networkpolicies"), testGeneratedPolicyName,
+						errors.New("conflict with other-manager"))
+				}
+
+				return false, nil, nil
+			})
+
+			Expect(remoteCluster.Distribute(context.TODO(), np)).To(Succeed())
+			Expect(conflicts).To(Equal(1))
+			Expect(patchActions(clientSet)).To(Equal(2))
+		})
+
+		It("Should delete it", func() {
+			Expect(remoteCluster.Delete(context.TODO(), np)).To(Succeed())
+			_, err := getPolicy()
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	When("the generated policy was modified by others", func() {
+		BeforeEach(func() {
+			modified := newGeneratedPolicy("")
+			delete(modified.Annotations, testObjIDAnnotation)
+			modified.Labels["other-controller"] = "true"
+			modified.Spec.Egress = []v1net.NetworkPolicyEgressRule{{}}
+			existing = []runtime.Object{modified}
+		})
+
+		It("Should repair the generated content and preserve the labels of others", func() {
+			Expect(remoteCluster.Repair(np)).To(Succeed())
+			repaired, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repaired.Annotations).To(HaveKeyWithValue(testObjIDAnnotation, "cluster-2:default/np1/uid"))
+			Expect(repaired.Labels).To(HaveKey("other-controller"))
+			Expect(repaired.Spec.Egress).To(BeEmpty())
+		})
+	})
+
+	When("the managed-by label was removed from the generated policy", func() {
+		BeforeEach(func() {
+			modified := newGeneratedPolicy("cluster-2:default/np1/uid")
+			modified.Labels = nil
+			modified.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: FieldManager, Operation: metav1.ManagedFieldsOperationApply}}
+			existing = []runtime.Object{modified}
+		})
+
+		It("Should still be owned thanks to the managed fields", func() {
+			Expect(remoteCluster.Repair(np)).To(Succeed())
+			repaired, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repaired.Labels).To(HaveKeyWithValue(ManagedByLabel, ManagedByValue))
+		})
+	})
+
+	When("the generated policy was generated by a former version", func() {
+		BeforeEach(func() {
+			np.Labels[testUIDLabel] = testUID
+			legacy := newGeneratedPolicy("cluster-2:default/np1/uid")
+			legacy.Labels = map[string]string{"submariner-io/coastguard-Np": "np1", testUIDLabel: testUID}
+			legacy.ManagedFields = []metav1.ManagedFieldsEntry{
+				{Manager: "coastguard-controller", Operation: metav1.ManagedFieldsOperationUpdate},
+			}
+			existing = []runtime.Object{legacy}
+		})
+
+		It("Should adopt it and add the managed-by label", func() {
+			Expect(remoteCluster.Repair(np)).To(Succeed())
+			repaired, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(repaired.Labels).To(HaveKeyWithValue(ManagedByLabel, ManagedByValue))
+			Expect(IsOwned(repaired, np)).To(BeTrue())
+		})
+
+		It("Should update it", func() {
+			Expect(remoteCluster.Distribute(context.TODO(), np)).To(Succeed())
+		})
+
+		It("Should delete it", func() {
+			Expect(remoteCluster.Delete(context.TODO(), np)).To(Succeed())
+			_, err := getPolicy()
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("Should refuse it for another original policy", func() {
+			np.Labels[testUIDLabel] = "other-uid"
+			Expect(remoteCluster.Distribute(context.TODO(), np)).To(MatchError(ErrConflict))
+		})
+	})
+
+	When("a policy with the same name is not managed by coastguard", func() {
+		BeforeEach(func() {
+			userPolicy := newGeneratedPolicy("")
+			userPolicy.Labels = nil
+			userPolicy.Annotations = nil
+			existing = []runtime.Object{userPolicy}
+		})
+
+		It("Should refuse to update it", func() {
+			Expect(remoteCluster.Distribute(context.TODO(), np)).To(MatchError(ErrConflict))
+			userPolicy, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(userPolicy.Labels).To(BeEmpty())
+		})
+
+		It("Should refuse to delete it", func() {
+			Expect(remoteCluster.Delete(context.TODO(), np)).To(MatchError(ErrConflict))
+			_, err := getPolicy()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should refuse to repair it", func() {
+			Expect(remoteCluster.Repair(np)).To(MatchError(ErrConflict))
+		})
+	})
+
+	When("a policy with the same name was generated from another original policy", func() {
+		BeforeEach(func() {
+			existing = []runtime.Object{newGeneratedPolicy("cluster-3:default/np1/other-uid")}
+		})
+
+		It("Should refuse to update it", func() {
+			Expect(remoteCluster.Distribute(context.TODO(), np)).To(MatchError(ErrConflict))
+		})
+	})
+})
+
+var _ = Describe("Finalizers of original policies", func() {
+	var (
+		remoteCluster *RemoteCluster
+		original      *v1net.NetworkPolicy
+	)
+
+	BeforeEach(func() {
+		original = &v1net.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "np1", UID: testUID}}
+		remoteCluster = New(clusterID1, fake.NewSimpleClientset(original))
+	})
+
+	getOriginal := func() *v1net.NetworkPolicy {
+		np, err := remoteCluster.ClientSet.NetworkingV1().NetworkPolicies(testNamespace).Get(context.TODO(), "np1", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		return np
+	}
+
+	It("Should add and remove the finalizer", func() {
+		Expect(remoteCluster.AddFinalizer(context.TODO(), original)).To(Succeed())
+		Expect(HasFinalizer(getOriginal())).To(BeTrue())
+
+		Expect(remoteCluster.RemoveFinalizer(context.TODO(), original)).To(Succeed())
+		Expect(HasFinalizer(getOriginal())).To(BeFalse())
+	})
+
+	It("Should ignore a policy which was replaced", func() {
+		replacement := original.DeepCopy()
+		replacement.UID = "other-uid"
+		Expect(remoteCluster.AddFinalizer(context.TODO(), replacement)).To(Succeed())
+		Expect(HasFinalizer(getOriginal())).To(BeFalse())
+	})
+
+	It("Should ignore a policy which is gone", func() {
+		original.Name = "gone"
+		Expect(remoteCluster.RemoveFinalizer(context.TODO(), original)).To(Succeed())
+	})
+})
+
+// newClientSetWithApply returns a fake clientset where server-side applying a NetworkPolicy which
+// doesn't exist creates it, like the API server does.
+func newClientSetWithApply(objects ...runtime.Object) *fake.Clientset {
+	clientSet := fake.NewSimpleClientset(objects...)
+	gvr := v1net.SchemeGroupVersion.WithResource("networkpolicies")
+
+	clientSet.PrependReactor("patch", "networkpolicies", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(k8stesting.PatchAction)
+		if patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		_, err := clientSet.Tracker().Get(gvr, patchAction.GetNamespace(), patchAction.GetName())
+		if !apierrors.IsNotFound(err) {
+			return false, nil, nil
+		}
+
+		np := &v1net.NetworkPolicy{}
+		if err := json.Unmarshal(patchAction.GetPatch(), np); err != nil {
+			return true, nil, err
+		}
+
+		return true, np, clientSet.Tracker().Create(gvr, np, patchAction.GetNamespace())
+	})
+
+	return clientSet
+}
+
+func patchActions(clientSet *fake.Clientset) int {
+	count := 0
+
+	for _, action := range clientSet.Actions() {
+		if action.GetVerb() == "patch" {
+			count++
+		}
+	}
+
+	return count
+}
+
+func newGeneratedPolicy(objID string) *v1net.NetworkPolicy {
+	return &v1net.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   testNamespace,
+			Name:        testGeneratedPolicyName,
+			Labels:      map[string]string{ManagedByLabel: ManagedByValue},
+			Annotations: map[string]string{testObjIDAnnotation: objID},
+		},
+	}
+}
@@ -0,0 +1,67 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// The component reported as the source of the Kubernetes events we record.
+const eventSourceComponent = "coastguard"
+
+// Reasons of the Kubernetes events we record.
+const (
+	ReasonDriftCorrected = "DriftCorrected"
+
+	// recorded on original policies
+	ReasonPolicyGenerated    = "PolicyGenerated"
+	ReasonPolicyUpdated      = "PolicyUpdated"
+	ReasonPolicyWithdrawn    = "PolicyWithdrawn"
+	ReasonUnsupportedPeer    = "UnsupportedPeer"
+	ReasonDistributionFailed = "DistributionFailed"
+	ReasonWithdrawalFailed   = "WithdrawalFailed"
+)
+
+func newEventRecorder() (record.EventBroadcaster, record.EventRecorder) {
+	broadcaster := record.NewBroadcaster()
+	return broadcaster, broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventSourceComponent})
+}
+
+// startRecordingEvents sends the recorded events to the cluster API until the cluster is stopped.
+func (rc *RemoteCluster) startRecordingEvents() {
+	rc.eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: rc.ClientSet.CoreV1().Events("")})
+
+	go func() {
+		<-rc.stopCh
+		rc.eventBroadcaster.Shutdown()
+	}()
+}
+
+// Eventf records a Kubernetes event on an object of the cluster, it's a no-op for virtual clusters.
+func (rc *RemoteCluster) Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if rc.eventRecorder == nil {
+		return
+	}
+
+	rc.eventRecorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,70 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	v1net "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Finalizer is set on the original NetworkPolicies which have a generated counterpart, so the generated
+// policy is deleted before the original disappears, even if coastguard is down when it's deleted.
+const Finalizer = "submariner-io/coastguard-cleanup"
+
+func HasFinalizer(np *v1net.NetworkPolicy) bool {
+	return controllerutil.ContainsFinalizer(np, Finalizer)
+}
+
+// AddFinalizer adds our finalizer to the original NetworkPolicy.
+func (rc *RemoteCluster) AddFinalizer(ctx context.Context, np *v1net.NetworkPolicy) error {
+	return rc.updateFinalizers(ctx, np, controllerutil.AddFinalizer)
+}
+
+// RemoveFinalizer removes our finalizer from the original NetworkPolicy, it's not an error if the policy is gone.
+func (rc *RemoteCluster) RemoveFinalizer(ctx context.Context, np *v1net.NetworkPolicy) error {
+	return rc.updateFinalizers(ctx, np, controllerutil.RemoveFinalizer)
+}
+
+func (rc *RemoteCluster) updateFinalizers(ctx context.Context, np *v1net.NetworkPolicy,
+	update func(o client.Object, finalizer string) bool,
+) error {
+	npClient := rc.ClientSet.NetworkingV1().NetworkPolicies(np.Namespace)
+
+	existing, err := npClient.Get(ctx, np.Name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "error getting NetworkPolicy %s from cluster %s", np.Name, rc.ClusterID)
+	}
+
+	if existing.UID != np.UID || !update(existing, Finalizer) {
+		return nil
+	}
+
+	// the resource version of existing makes sure we don't overwrite finalizers we haven't seen
+	_, err = npClient.Update(ctx, existing, v1.UpdateOptions{})
+
+	return errors.Wrapf(err, "error updating the finalizers of NetworkPolicy %s in cluster %s", np.Name, rc.ClusterID)
+}
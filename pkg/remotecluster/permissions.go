@@ -0,0 +1,237 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is an action coastguard needs to perform on a cluster, in a namespace or cluster-wide.
+type Permission struct {
+	Verb      string `json:"verb"`
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+
+	if p.Namespace == "" {
+		return fmt.Sprintf("%s %s", p.Verb, resource)
+	}
+
+	return fmt.Sprintf("%s %s in namespace %s", p.Verb, resource, p.Namespace)
+}
+
+type requiredResource struct {
+	group    string
+	resource string
+	verbs    []string
+}
+
+// requiredResources are the resources coastguard needs in every watched namespace.
+var requiredResources = []requiredResource{
+	{resource: "pods", verbs: []string{"list", "watch"}},
+	{
+		group: "networking.k8s.io", resource: "networkpolicies",
+		verbs: []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+	},
+	{group: "discovery.k8s.io", resource: "endpointslices", verbs: []string{"list", "watch"}},
+	{resource: "events", verbs: []string{"create", "patch"}},
+}
+
+// RequiredPermissions returns the permissions coastguard needs on a cluster with the given scope, where
+// namespaces are the namespaces currently matching the namespace selector of the scope, if any.
+func RequiredPermissions(scope *Scope, selectedNamespaces []string) []Permission {
+	namespaces := []string{metav1.NamespaceAll}
+
+	switch {
+	case len(scope.Namespaces) > 0:
+		namespaces = scope.Namespaces
+	case scope.NamespaceSelector != "":
+		namespaces = selectedNamespaces
+	}
+
+	permissions := []Permission{}
+
+	if scope.NamespaceSelector != "" {
+		permissions = append(permissions, Permission{Verb: "list", Resource: "namespaces"},
+			Permission{Verb: "watch", Resource: "namespaces"})
+	}
+
+	for _, namespace := range namespaces {
+		for _, required := range requiredResources {
+			for _, verb := range required.verbs {
+				permissions = append(permissions, Permission{
+					Verb: verb, Group: required.group, Resource: required.resource, Namespace: namespace,
+				})
+			}
+		}
+	}
+
+	return permissions
+}
+
+// CheckPermissions reviews the permissions coastguard needs on a cluster with the given scope, plus the extra
+// cluster-wide ones, and returns the missing ones. The permissions in a namespace are reviewed with a single
+// SelfSubjectRulesReview, falling back to SelfSubjectAccessReviews when its rules are incomplete, and the
+// cluster-wide ones with SelfSubjectAccessReviews.
+func CheckPermissions(ctx context.Context, clientSet kubernetes.Interface, scope *Scope, extra []Permission) ([]Permission, error) {
+	var selectedNamespaces []string
+
+	if scope.NamespaceSelector != "" {
+		// if namespaces can't be listed, that's reported as a missing permission
+		namespaces, err := clientSet.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: scope.NamespaceSelector})
+		if err == nil {
+			for i := range namespaces.Items {
+				selectedNamespaces = append(selectedNamespaces, namespaces.Items[i].Name)
+			}
+		}
+	}
+
+	required := RequiredPermissions(scope, selectedNamespaces)
+	seen := map[Permission]bool{}
+
+	for _, permission := range required {
+		seen[permission] = true
+	}
+
+	for _, permission := range extra {
+		if !seen[permission] {
+			seen[permission] = true
+			required = append(required, permission)
+		}
+	}
+
+	missing := []Permission{}
+	rules := map[string]*authorizationv1.SubjectRulesReviewStatus{}
+
+	for _, permission := range required {
+		allowed, err := isAllowed(ctx, clientSet, permission, rules)
+		if err != nil {
+			return nil, err
+		}
+
+		if !allowed {
+			missing = append(missing, permission)
+		}
+	}
+
+	return missing, nil
+}
+
+// isAllowed reviews the permission, the rules of each namespace are reviewed once.
+func isAllowed(ctx context.Context, clientSet kubernetes.Interface, permission Permission,
+	rules map[string]*authorizationv1.SubjectRulesReviewStatus,
+) (bool, error) {
+	if permission.Namespace != metav1.NamespaceAll {
+		status, reviewed := rules[permission.Namespace]
+		if !reviewed {
+			review, err := clientSet.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx,
+				&authorizationv1.SelfSubjectRulesReview{
+					Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: permission.Namespace},
+				}, metav1.CreateOptions{})
+			if err != nil {
+				return false, errors.Wrapf(err, "error reviewing the permissions in namespace %s", permission.Namespace)
+			}
+
+			status = &review.Status
+			rules[permission.Namespace] = status
+		}
+
+		if rulesAllow(status.ResourceRules, permission) {
+			return true, nil
+		}
+
+		// incomplete rules, i.e. with a webhook authorizer, can't tell what is denied
+		if !status.Incomplete {
+			return false, nil
+		}
+	}
+
+	review, err := clientSet.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx,
+		&authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: permission.Namespace,
+					Verb:      permission.Verb,
+					Group:     permission.Group,
+					Resource:  permission.Resource,
+				},
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "error reviewing the permission to %s", permission)
+	}
+
+	return review.Status.Allowed, nil
+}
+
+// rulesAllow returns true if one of the rules allows the permission on every object of the resource.
+func rulesAllow(rules []authorizationv1.ResourceRule, permission Permission) bool {
+	for i := range rules {
+		if len(rules[i].ResourceNames) == 0 && containsOrWildcard(rules[i].Verbs, permission.Verb) &&
+			containsOrWildcard(rules[i].APIGroups, permission.Group) && containsOrWildcard(rules[i].Resources, permission.Resource) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsOrWildcard(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetMissingPermissions records the permissions found missing on the cluster, which is degraded
+// while any is missing.
+func (rc *RemoteCluster) SetMissingPermissions(missing []Permission) {
+	rc.permissionsMutex.Lock()
+	defer rc.permissionsMutex.Unlock()
+
+	rc.missingPermissions = missing
+}
+
+func (rc *RemoteCluster) MissingPermissions() []Permission {
+	rc.permissionsMutex.Lock()
+	defer rc.permissionsMutex.Unlock()
+
+	return rc.missingPermissions
+}
+
+// IsDegraded returns true if coastguard lacks permissions on the cluster.
+func (rc *RemoteCluster) IsDegraded() bool {
+	return len(rc.MissingPermissions()) > 0
+}
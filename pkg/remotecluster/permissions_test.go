@@ -0,0 +1,164 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Permission checks", func() {
+	var denied []Permission
+
+	BeforeEach(func() {
+		denied = nil
+	})
+
+	check := func(scope Scope, objects ...runtime.Object) []Permission {
+		missing, err := CheckPermissions(context.TODO(), newClientSetWithAccessReviews(denied, false, objects...), &scope, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		return missing
+	}
+
+	It("Should find nothing missing when everything is allowed", func() {
+		Expect(check(Scope{})).To(BeEmpty())
+	})
+
+	It("Should report the missing cluster-wide permissions", func() {
+		denied = []Permission{
+			{Verb: "watch", Resource: "pods"},
+			{Verb: "delete", Group: "networking.k8s.io", Resource: "networkpolicies"},
+		}
+
+		missing := check(Scope{})
+		Expect(missing).To(Equal(denied))
+		Expect(missing[1].String()).To(Equal("delete networkpolicies.networking.k8s.io"))
+	})
+
+	It("Should review the permissions in each watched namespace", func() {
+		denied = []Permission{{Verb: "list", Group: "discovery.k8s.io", Resource: "endpointslices", Namespace: "billing"}}
+
+		missing := check(Scope{Namespaces: []string{"payments", "billing"}})
+		Expect(missing).To(Equal(denied))
+		Expect(missing[0].String()).To(Equal("list endpointslices.discovery.k8s.io in namespace billing"))
+	})
+
+	It("Should review the permissions on namespaces and in the selected namespaces", func() {
+		denied = []Permission{
+			{Verb: "watch", Resource: "namespaces"},
+			{Verb: "create", Resource: "events", Namespace: "payments"},
+			{Verb: "create", Resource: "events", Namespace: "other"},
+		}
+
+		missing := check(Scope{NamespaceSelector: "tenant=a"},
+			newNamespace("payments", map[string]string{"tenant": "a"}), newNamespace("other", nil))
+		Expect(missing).To(Equal(denied[:2]))
+	})
+
+	It("Should review the rules of each namespace once", func() {
+		clientSet := newClientSetWithAccessReviews(denied, false)
+		_, err := CheckPermissions(context.TODO(), clientSet, &Scope{Namespaces: []string{"payments", "billing"}}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reviews(clientSet, "selfsubjectrulesreviews")).To(Equal(2))
+		Expect(reviews(clientSet, "selfsubjectaccessreviews")).To(BeZero())
+	})
+
+	It("Should fall back to access reviews when the rules are incomplete", func() {
+		denied = []Permission{{Verb: "list", Resource: "pods", Namespace: "payments"}}
+		clientSet := newClientSetWithAccessReviews(denied, true)
+		missing, err := CheckPermissions(context.TODO(), clientSet, &Scope{Namespaces: []string{"payments"}}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(missing).To(Equal(denied))
+		Expect(reviews(clientSet, "selfsubjectaccessreviews")).To(Equal(1))
+	})
+
+	It("Should review the extra permissions once", func() {
+		denied = []Permission{{Verb: "list", Group: "k8s.ovn.org", Resource: "egressips"}}
+		extra := []Permission{{Verb: "list", Resource: "pods"}, {Verb: "list", Group: "k8s.ovn.org", Resource: "egressips"}}
+
+		missing, err := CheckPermissions(context.TODO(), newClientSetWithAccessReviews(denied, false), &Scope{}, extra)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(missing).To(Equal(denied))
+	})
+})
+
+// newClientSetWithAccessReviews returns a fake clientset allowing everything but the denied permissions, the rules
+// of a namespace allowing the required resources, and being incomplete when asked for.
+func newClientSetWithAccessReviews(denied []Permission, incompleteRules bool, objects ...runtime.Object) *fake.Clientset {
+	clientSet := fake.NewSimpleClientset(objects...)
+
+	isDenied := func(requested Permission) bool {
+		for _, permission := range denied {
+			if permission == requested {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	clientSet.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = !isDenied(Permission{
+			Verb: attributes.Verb, Group: attributes.Group, Resource: attributes.Resource, Namespace: attributes.Namespace,
+		})
+
+		return true, review, nil
+	})
+
+	clientSet.PrependReactor("create", "selfsubjectrulesreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+		review.Status.Incomplete = incompleteRules
+
+		for _, required := range requiredResources {
+			for _, verb := range required.verbs {
+				permission := Permission{Verb: verb, Group: required.group, Resource: required.resource, Namespace: review.Spec.Namespace}
+				if !isDenied(permission) {
+					review.Status.ResourceRules = append(review.Status.ResourceRules, authorizationv1.ResourceRule{
+						Verbs: []string{verb}, APIGroups: []string{required.group}, Resources: []string{required.resource},
+					})
+				}
+			}
+		}
+
+		return true, review, nil
+	})
+
+	return clientSet
+}
+
+func reviews(clientSet *fake.Clientset, resource string) int {
+	count := 0
+
+	for _, action := range clientSet.Actions() {
+		if action.GetVerb() == "create" && action.GetResource().Resource == resource {
+			count++
+		}
+	}
+
+	return count
+}
@@ -19,31 +19,66 @@ limitations under the License.
 package remotecluster
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/submariner-io/coastguard/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	v1net "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
-const defaultResyncTime = time.Hour * 24
+// resyncPeriod is the resync period of the informers of the clusters.
+var resyncPeriod = time.Hour * 24
+
+// SetResyncPeriod sets the resync period of the informers, it must be called before any cluster is created.
+func SetResyncPeriod(period time.Duration) {
+	resyncPeriod = period
+}
 
 type RemoteCluster struct {
 	stopCh chan struct{}
 
-	ClusterID             string
-	ClientSet             kubernetes.Interface
-	podInformer           cache.SharedIndexInformer
-	networkPolicyInformer cache.SharedIndexInformer
+	ClusterID string
+	ClientSet kubernetes.Interface
+
+	// scope limits the watched namespaces and pods
+	scope Scope
+
+	// informerSets watch the objects of each watched namespace, metav1.NamespaceAll when not limited
+	informersMutex         *sync.Mutex
+	informerSets           map[string]*informerSet
+	namespaceInformer      cache.SharedIndexInformer
+	namespaceHandlerSynced func() bool
 
 	eventChanMutex *sync.Mutex
 	eventChan      chan *Event
+
+	// sourceIPsPending is true while a change of the source IPs waits to be handled by the processing loop
+	sourceIPResolverMutex *sync.Mutex
+	sourceIPResolver      SourceIPResolver
+	sourceIPsPending      bool
+
+	// secondaryNetworks are the Multus networks whose pod addresses are included as peers
+	secondaryNetworks []string
+
+	// missingPermissions are the permissions found missing by the last permission check
+	permissionsMutex   *sync.Mutex
+	missingPermissions []Permission
+
+	// eventRecorder records Kubernetes events on the objects of this cluster
+	eventBroadcaster record.EventBroadcaster
+	eventRecorder    record.EventRecorder
 }
 
 type EventType string
@@ -59,14 +94,60 @@ type ObjectType string
 const (
 	NetworkPolicy ObjectType = "np"
 	Pod           ObjectType = "pod"
+	EndpointSlice ObjectType = "eps"
+	// SourceIPs events carry no objects, they signal that the source IPs of the cluster pods may have changed
+	SourceIPs ObjectType = "sourceips"
+	// Cluster events carry no objects, a DeleteEvent signals that the cluster was unregistered
+	Cluster ObjectType = "cluster"
 )
 
+// LogKey returns the key of the ObjIDs of this type of object in the logs.
+func (t ObjectType) LogKey() string {
+	switch t {
+	case NetworkPolicy:
+		return "policy"
+	case Pod:
+		return "pod"
+	case EndpointSlice:
+		return "endpointSlice"
+	case SourceIPs, Cluster:
+	}
+
+	return "object"
+}
+
 type Event struct {
 	Cluster *RemoteCluster
 	Type    EventType
 	ObjType ObjectType
 	Objs    []interface{}
 	ObjID   string
+	// Time is when the change was seen
+	Time time.Time
+	// SpanContext is the span which handled the event last, the processing of the event continues its trace
+	SpanContext trace.SpanContext
+}
+
+// TraceAttributes returns the attributes describing the event on its spans.
+func (ev *Event) TraceAttributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		tracing.ClusterKey.String(ev.Cluster.ClusterID),
+		tracing.EventTypeKey.String(string(ev.Type)),
+		tracing.ObjTypeKey.String(string(ev.ObjType)),
+	}
+
+	switch ev.ObjType {
+	case NetworkPolicy:
+		attrs = append(attrs, tracing.PolicyKey.String(ev.ObjID))
+	case Pod:
+		attrs = append(attrs, tracing.PodKey.String(ev.ObjID))
+	case EndpointSlice, SourceIPs, Cluster:
+		if ev.ObjID != "" {
+			attrs = append(attrs, tracing.ObjectKey.String(ev.ObjID))
+		}
+	}
+
+	return attrs
 }
 
 func (ev *Event) ToUpdatedFrom(oldObj interface{}) *Event {
@@ -74,7 +155,7 @@ func (ev *Event) ToUpdatedFrom(oldObj interface{}) *Event {
 		ev.Objs = append([]interface{}{oldObj}, ev.Objs[0])
 		ev.Type = UpdateEvent
 	} else {
-		klog.Fatal("only AddEvents can be converted to UpdateEvents")
+		klog.Fatal("Only AddEvents can be converted to UpdateEvents")
 	}
 
 	return ev
@@ -85,35 +166,87 @@ func (ev *Event) ToAdded() *Event {
 		ev.Objs = []interface{}{ev.Objs[1]}
 		ev.Type = AddEvent
 	} else {
-		klog.Fatal("only UpdateEvents can be converted to AddEvents")
+		klog.Fatal("Only UpdateEvents can be converted to AddEvents")
 	}
 
 	return ev
 }
 
 func New(clusterID string, clientSet kubernetes.Interface) *RemoteCluster {
-	factory := informers.NewSharedInformerFactory(clientSet, defaultResyncTime)
-	podInformer := factory.Core().V1().Pods().Informer()
-	networkPolicyInformer := factory.Networking().V1().NetworkPolicies().Informer()
+	return NewScoped(clusterID, clientSet, Scope{})
+}
+
+// NewScoped creates a RemoteCluster only watching the namespaces and pods in scope.
+func NewScoped(clusterID string, clientSet kubernetes.Interface, scope Scope) *RemoteCluster {
+	eventBroadcaster, eventRecorder := newEventRecorder()
 
 	resourceWatcher := &RemoteCluster{
 		stopCh:                make(chan struct{}),
 		ClusterID:             clusterID,
 		ClientSet:             clientSet,
-		podInformer:           podInformer,
-		networkPolicyInformer: networkPolicyInformer,
+		scope:                 scope,
+		informersMutex:        &sync.Mutex{},
+		informerSets:          map[string]*informerSet{},
 		eventChanMutex:        &sync.Mutex{},
+		sourceIPResolverMutex: &sync.Mutex{},
+		permissionsMutex:      &sync.Mutex{},
+		eventBroadcaster:      eventBroadcaster,
+		eventRecorder:         eventRecorder,
 	}
 
-	_, _ = podInformer.AddEventHandler(resourceWatcher)
-	_, _ = networkPolicyInformer.AddEventHandler(resourceWatcher)
+	switch {
+	case scope.NamespaceSelector != "":
+		resourceWatcher.watchSelectedNamespaces()
+	case len(scope.Namespaces) > 0:
+		for _, namespace := range scope.Namespaces {
+			resourceWatcher.informerSets[namespace] = newInformerSet(clientSet, namespace, &scope, resourceWatcher)
+		}
+	default:
+		resourceWatcher.informerSets[metav1.NamespaceAll] = newInformerSet(clientSet, metav1.NamespaceAll, &scope,
+			resourceWatcher)
+	}
 
 	return resourceWatcher
 }
 
+// NewVirtual creates a RemoteCluster with no API server behind it, which is used to
+// attribute objects that don't come from any cluster, like external workloads.
+func NewVirtual(clusterID string) *RemoteCluster {
+	return &RemoteCluster{
+		stopCh:                make(chan struct{}),
+		ClusterID:             clusterID,
+		informersMutex:        &sync.Mutex{},
+		informerSets:          map[string]*informerSet{},
+		eventChanMutex:        &sync.Mutex{},
+		sourceIPResolverMutex: &sync.Mutex{},
+		permissionsMutex:      &sync.Mutex{},
+	}
+}
+
 func (rc *RemoteCluster) HasSynced() bool {
-	return rc.podInformer.HasSynced() &&
-		rc.networkPolicyInformer.HasSynced()
+	if rc.namespaceInformer != nil && !(rc.namespaceInformer.HasSynced() && rc.namespaceHandlerSynced()) {
+		return false
+	}
+
+	for _, set := range rc.currentInformerSets() {
+		if !set.hasSynced() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rc *RemoteCluster) currentInformerSets() []*informerSet {
+	rc.informersMutex.Lock()
+	defer rc.informersMutex.Unlock()
+
+	sets := make([]*informerSet, 0, len(rc.informerSets))
+	for _, set := range rc.informerSets {
+		sets = append(sets, set)
+	}
+
+	return sets
 }
 
 // Stop will stop the running informers.
@@ -133,16 +266,19 @@ func (rc *RemoteCluster) Stopped() bool {
 }
 
 func (rc *RemoteCluster) Run(onSyncDoneFunc func(resourceWatcher *RemoteCluster)) {
-	go rc.podInformer.Run(rc.stopCh)
-	go rc.networkPolicyInformer.Run(rc.stopCh)
+	if rc.namespaceInformer != nil {
+		go rc.namespaceInformer.Run(rc.stopCh)
+	}
 
-	go func() {
-		if !cache.WaitForCacheSync(rc.stopCh, rc.podInformer.HasSynced) {
-			klog.Warning("Timed out waiting for pod informer to sync")
-		}
+	for _, set := range rc.currentInformerSets() {
+		set.run(rc.stopCh)
+	}
 
-		if !cache.WaitForCacheSync(rc.stopCh, rc.networkPolicyInformer.HasSynced) {
-			klog.Warning("Timed out waiting for NetworkPolicy informer to sync")
+	rc.startRecordingEvents()
+
+	go func() {
+		if !cache.WaitForCacheSync(rc.stopCh, rc.HasSynced) {
+			klog.InfoS("Timed out waiting for the informers to sync", "cluster", rc.ClusterID)
 		}
 
 		if onSyncDoneFunc != nil {
@@ -151,12 +287,49 @@ func (rc *RemoteCluster) Run(onSyncDoneFunc func(resourceWatcher *RemoteCluster)
 	}()
 }
 
+// SetSecondaryNetworks sets the Multus secondary networks whose pod addresses are included
+// as peers by every policy, it must be called before Run.
+func (rc *RemoteCluster) SetSecondaryNetworks(networks []string) {
+	rc.secondaryNetworks = networks
+}
+
+func (rc *RemoteCluster) SecondaryNetworks() []string {
+	return rc.secondaryNetworks
+}
+
+// PodInformer returns the informer of the cluster pods, so others can follow them
+// without creating a second informer. It's nil when the watched namespaces are limited.
+func (rc *RemoteCluster) PodInformer() cache.SharedIndexInformer {
+	rc.informersMutex.Lock()
+	defer rc.informersMutex.Unlock()
+
+	if set, exists := rc.informerSets[metav1.NamespaceAll]; exists {
+		return set.podInformer
+	}
+
+	return nil
+}
+
 func (rc *RemoteCluster) GetPods() []interface{} {
-	return rc.podInformer.GetStore().List()
+	return rc.listAll(func(set *informerSet) cache.SharedIndexInformer { return set.podInformer })
 }
 
 func (rc *RemoteCluster) GetNetworkPolicies() []interface{} {
-	return rc.networkPolicyInformer.GetStore().List()
+	return rc.listAll(func(set *informerSet) cache.SharedIndexInformer { return set.networkPolicyInformer })
+}
+
+func (rc *RemoteCluster) GetEndpointSlices() []interface{} {
+	return rc.listAll(func(set *informerSet) cache.SharedIndexInformer { return set.endpointSliceInformer })
+}
+
+func (rc *RemoteCluster) listAll(informerOf func(set *informerSet) cache.SharedIndexInformer) []interface{} {
+	objs := []interface{}{}
+
+	for _, set := range rc.currentInformerSets() {
+		objs = append(objs, informerOf(set).GetStore().List()...)
+	}
+
+	return objs
 }
 
 func (rc *RemoteCluster) SetEventChannel(eventChan chan *Event) {
@@ -166,15 +339,28 @@ func (rc *RemoteCluster) SetEventChannel(eventChan chan *Event) {
 }
 
 func (rc *RemoteCluster) OnAdd(obj interface{}, _ bool) {
-	rc.enqueueEvent(rc.NewAddEvent(obj))
+	rc.traceEvent("RemoteCluster.OnAdd", rc.NewAddEvent(obj))
 }
 
 func (rc *RemoteCluster) OnDelete(obj interface{}) {
-	rc.enqueueEvent(rc.NewDeleteEvent(obj))
+	rc.traceEvent("RemoteCluster.OnDelete", rc.NewDeleteEvent(obj))
 }
 
 func (rc *RemoteCluster) OnUpdate(oldObj, newObj interface{}) {
-	rc.enqueueEvent(rc.NewUpdateEvent(oldObj, newObj))
+	rc.traceEvent("RemoteCluster.OnUpdate", rc.NewUpdateEvent(oldObj, newObj))
+}
+
+// traceEvent enqueues the event in a new trace, whose span covers the wait for room in the queue.
+func (rc *RemoteCluster) traceEvent(name string, event *Event) {
+	if event == nil {
+		return
+	}
+
+	_, span := tracing.Start(context.Background(), name, event.TraceAttributes()...)
+	event.SpanContext = span.SpanContext()
+
+	rc.enqueueEvent(event)
+	span.End()
 }
 
 func (rc *RemoteCluster) enqueueEvent(event *Event) {
@@ -196,6 +382,7 @@ func (rc *RemoteCluster) NewAddEvent(objInterface interface{}) *Event {
 	event := Event{
 		Cluster: rc,
 		Type:    AddEvent,
+		Time:    time.Now(),
 		Objs:    []interface{}{objInterface},
 	}
 
@@ -206,6 +393,7 @@ func (rc *RemoteCluster) NewUpdateEvent(objInterface, newObjInterface interface{
 	event := Event{
 		Cluster: rc,
 		Type:    UpdateEvent,
+		Time:    time.Now(),
 		Objs:    []interface{}{objInterface, newObjInterface},
 	}
 
@@ -216,6 +404,7 @@ func (rc *RemoteCluster) NewDeleteEvent(objInterface interface{}) *Event {
 	event := Event{
 		Cluster: rc,
 		Type:    DeleteEvent,
+		Time:    time.Now(),
 		Objs:    []interface{}{objInterface},
 	}
 
@@ -230,10 +419,14 @@ func (rc *RemoteCluster) extractEventDetails(objInterface interface{}, event *Ev
 	case *v1net.NetworkPolicy:
 		event.ObjType = NetworkPolicy
 		event.ObjID = ObjID(rc.ClusterID, obj.Namespace, obj.Name, obj.UID)
+	case *discoveryv1.EndpointSlice:
+		event.ObjType = EndpointSlice
+		event.ObjID = ObjID(rc.ClusterID, obj.Namespace, obj.Name, obj.UID)
 	case cache.DeletedFinalStateUnknown:
 		return rc.extractEventDetails(obj.Obj, event)
 	default:
-		klog.Errorf("%s for unexpected type object: %v", event.Type, objInterface)
+		klog.ErrorS(nil, "Ignoring an event for an object of unexpected type", "cluster", rc.ClusterID, "type", event.Type,
+			"objectType", fmt.Sprintf("%T", objInterface))
 		return nil
 	}
 
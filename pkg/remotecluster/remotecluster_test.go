@@ -25,6 +25,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	v1net "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -71,6 +72,17 @@ func describeExtractEventDetails() {
 		})
 	})
 
+	When("an EndpointSlice event is processed", func() {
+		It("Should extract details properly", func() {
+			eps := &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{
+				Namespace: testNamespace, Name: testEndpointSliceName, UID: testUID,
+			}}
+			event := remoteCluster.extractEventDetails(eps, &Event{})
+			Expect(event.ObjType).To(Equal(EndpointSlice))
+			Expect(event.ObjID).To(Equal(clusterID1 + ":" + testNamespace + "/" + testEndpointSliceName + "/" + testUID))
+		})
+	})
+
 	When("a cache.DeletedFinalStateUnknown containing another obj is processed", func() {
 		It("Should extract a tombstone Pod properly", func() {
 			dfsu := cache.DeletedFinalStateUnknown{Obj: NewPod(testPodName)}
@@ -294,6 +306,40 @@ func describeRemoteCluster() {
 			Expect(remoteCluster.Stopped()).To(BeTrue())
 		})
 	})
+	Context("Source IP resolution", func() {
+		It("Should resolve pods to their IPs by default", func() {
+			pod := NewPod(testPodName)
+			pod.Status.PodIP = "10.0.0.1"
+			Expect(NewVirtual(clusterID1).SourceIPs(pod)).To(Equal([]string{"10.0.0.1"}))
+
+			pod.Status.PodIPs = []v1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}}
+			Expect(NewVirtual(clusterID1).SourceIPs(pod)).To(Equal([]string{"10.0.0.1", "fd00::1"}))
+		})
+
+		It("Should send an event when the resolved source IPs change", func() {
+			remoteCluster := NewVirtual(clusterID1)
+			remoteCluster.SetEventChannel(eventChannel)
+			remoteCluster.sourceIPsChanged()
+
+			var event *Event
+			Eventually(eventChannel).Should(Receive(&event))
+			Expect(event.ObjType).Should(Equal(SourceIPs))
+		})
+
+		It("Should coalesce the source IPs changes until the pending one is handled", func() {
+			remoteCluster := NewVirtual(clusterID1)
+			remoteCluster.SetEventChannel(eventChannel)
+			remoteCluster.sourceIPsChanged()
+			remoteCluster.sourceIPsChanged()
+
+			Eventually(eventChannel).Should(Receive())
+			Consistently(eventChannel).ShouldNot(Receive())
+
+			remoteCluster.SourceIPsRefreshed()
+			remoteCluster.sourceIPsChanged()
+			Eventually(eventChannel).Should(Receive())
+		})
+	})
 	Context("Access to the informers cache", func() {
 		It("Should be able to list existing pods in informer cache", func() {
 			remoteCluster, _ := createRemoteClusterWithPod(eventChannel)
@@ -340,6 +386,7 @@ const (
 	testPodName           = "pod1"
 	testPodNameOld        = "pod1-old"
 	testNetworkPolicyName = "np1"
+	testEndpointSliceName = "eps1"
 	testUID               = "ff3b5269-1201-4e2c-95f5-46fc69ff6c63"
 )
 
@@ -0,0 +1,319 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	v1net "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// LabelMultiClusterServiceName labels the EndpointSlices of the services exported to the cluster set,
+// per the multicluster services API.
+const LabelMultiClusterServiceName = "multicluster.kubernetes.io/service-name"
+
+// AllClusters is the key used to configure the scope of every cluster which has no specific scope.
+const AllClusters = "*"
+
+// Scope limits what is watched in a cluster, i.e. when cluster-wide permissions can't be granted.
+// The zero Scope watches every namespace.
+type Scope struct {
+	// Namespaces are the only namespaces watched, which only requires namespaced permissions
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// NamespaceSelector is a label selector for the watched namespaces, which requires permissions
+	// to list and watch namespaces, it can't be used with Namespaces
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+
+	// PodSelector is a label selector for the watched pods, applied by the API server
+	PodSelector string `json:"podSelector,omitempty"`
+}
+
+// ScopesFile is the file configuring the scope of each cluster, indexed by cluster ID.
+type ScopesFile struct {
+	Clusters map[string]Scope `json:"clusters"`
+}
+
+// LoadScopes reads and validates a scopes YAML file.
+func LoadScopes(path string) (map[string]Scope, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading cluster scopes file %s", path)
+	}
+
+	file := &ScopesFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, errors.Wrapf(err, "error parsing cluster scopes file %s", path)
+	}
+
+	for clusterID, scope := range file.Clusters {
+		if err := scope.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid scope for cluster %q", clusterID)
+		}
+	}
+
+	return file.Clusters, nil
+}
+
+// ScopeFor returns the scope configured for clusterID.
+func ScopeFor(scopes map[string]Scope, clusterID string) Scope {
+	if scope, exists := scopes[clusterID]; exists {
+		return scope
+	}
+
+	return scopes[AllClusters]
+}
+
+func (s *Scope) validate() error {
+	if len(s.Namespaces) > 0 && s.NamespaceSelector != "" {
+		return errors.New("namespaces and namespaceSelector can't be used together")
+	}
+
+	for _, selector := range []string{s.NamespaceSelector, s.PodSelector} {
+		if _, err := labels.Parse(selector); err != nil {
+			return errors.Wrapf(err, "invalid label selector %q", selector)
+		}
+	}
+
+	return nil
+}
+
+// IsLimited returns true if the scope doesn't cover every pod of the cluster.
+func (s *Scope) IsLimited() bool {
+	return len(s.Namespaces) > 0 || s.NamespaceSelector != "" || s.PodSelector != ""
+}
+
+func (s Scope) String() string {
+	var description string
+
+	switch {
+	case len(s.Namespaces) > 0:
+		description = "namespaces " + strings.Join(s.Namespaces, ", ")
+	case s.NamespaceSelector != "":
+		description = fmt.Sprintf("namespaces matching %q", s.NamespaceSelector)
+	default:
+		description = "all namespaces"
+	}
+
+	if s.PodSelector != "" {
+		description += fmt.Sprintf(", pods matching %q", s.PodSelector)
+	}
+
+	return description
+}
+
+// informerSet watches the objects we need in one namespace, or in all of them.
+type informerSet struct {
+	namespace             string
+	podInformer           cache.SharedIndexInformer
+	networkPolicyInformer cache.SharedIndexInformer
+	endpointSliceInformer cache.SharedIndexInformer
+	stopCh                chan struct{}
+}
+
+func newInformerSet(clientSet kubernetes.Interface, namespace string, scope *Scope, handler cache.ResourceEventHandler,
+) *informerSet {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncPeriod, informers.WithNamespace(namespace))
+	networkPolicyInformer := factory.Networking().V1().NetworkPolicies().Informer()
+
+	podFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncPeriod, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = scope.PodSelector
+		}))
+	podInformer := podFactory.Core().V1().Pods().Informer()
+	_ = podInformer.SetTransform(TrimPod)
+
+	// we only care about EndpointSlices backing exported services, which can be selected as ingress peers
+	serviceFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, resyncPeriod, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = LabelMultiClusterServiceName
+		}))
+	endpointSliceInformer := serviceFactory.Discovery().V1().EndpointSlices().Informer()
+
+	_, _ = podInformer.AddEventHandler(handler)
+	_, _ = networkPolicyInformer.AddEventHandler(handler)
+	_, _ = endpointSliceInformer.AddEventHandler(handler)
+
+	return &informerSet{
+		namespace:             namespace,
+		podInformer:           podInformer,
+		networkPolicyInformer: networkPolicyInformer,
+		endpointSliceInformer: endpointSliceInformer,
+		stopCh:                make(chan struct{}),
+	}
+}
+
+func (s *informerSet) informers() []cache.SharedIndexInformer {
+	return []cache.SharedIndexInformer{s.podInformer, s.networkPolicyInformer, s.endpointSliceInformer}
+}
+
+// run starts the informers until the set or the cluster are stopped.
+func (s *informerSet) run(clusterStopCh <-chan struct{}) {
+	stopCh := make(chan struct{})
+
+	go func() {
+		select {
+		case <-s.stopCh:
+		case <-clusterStopCh:
+		}
+
+		close(stopCh)
+	}()
+
+	for _, informer := range s.informers() {
+		go informer.Run(stopCh)
+	}
+}
+
+func (s *informerSet) hasSynced() bool {
+	for _, informer := range s.informers() {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// watchSelectedNamespaces follows the namespaces matching the namespace selector of the scope,
+// watching the objects of every namespace while it matches.
+func (rc *RemoteCluster) watchSelectedNamespaces() {
+	factory := informers.NewSharedInformerFactoryWithOptions(rc.ClientSet, resyncPeriod,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = rc.scope.NamespaceSelector
+		}))
+	rc.namespaceInformer = factory.Core().V1().Namespaces().Informer()
+
+	// namespaces which stop matching the selector are deleted from the watch
+	registration, _ := rc.namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			rc.addNamespace(obj.(*v1.Namespace).Name)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			if ns, ok := obj.(*v1.Namespace); ok {
+				rc.removeNamespace(ns.Name)
+			}
+		},
+	})
+
+	rc.namespaceHandlerSynced = registration.HasSynced
+}
+
+func (rc *RemoteCluster) addNamespace(namespace string) {
+	rc.informersMutex.Lock()
+	defer rc.informersMutex.Unlock()
+
+	if _, exists := rc.informerSets[namespace]; exists {
+		return
+	}
+
+	klog.InfoS("Watching namespace", "cluster", rc.ClusterID, "namespace", namespace)
+
+	set := newInformerSet(rc.ClientSet, namespace, &rc.scope, rc)
+	rc.informerSets[namespace] = set
+	set.run(rc.stopCh)
+}
+
+// removeNamespace stops watching the namespace, and signals the deletion of every object we knew in it.
+func (rc *RemoteCluster) removeNamespace(namespace string) {
+	rc.informersMutex.Lock()
+	set, exists := rc.informerSets[namespace]
+	delete(rc.informerSets, namespace)
+	rc.informersMutex.Unlock()
+
+	if !exists {
+		return
+	}
+
+	klog.InfoS("No longer watching namespace", "cluster", rc.ClusterID, "namespace", namespace)
+	close(set.stopCh)
+
+	for _, informer := range set.informers() {
+		for _, obj := range informer.GetStore().List() {
+			rc.OnDelete(obj)
+		}
+	}
+}
+
+// Scope returns the scope of what is watched in the cluster.
+func (rc *RemoteCluster) Scope() Scope {
+	return rc.scope
+}
+
+// WatchedNamespaces returns the namespaces currently watched, an empty list meaning all of them.
+func (rc *RemoteCluster) WatchedNamespaces() []string {
+	rc.informersMutex.Lock()
+	defer rc.informersMutex.Unlock()
+
+	namespaces := []string{}
+
+	for namespace := range rc.informerSets {
+		if namespace != metav1.NamespaceAll {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+// ListNetworkPolicies lists the NetworkPolicies matching the label selector from the API server, only in
+// the watched namespaces, so the namespaced permissions of a limited scope are enough.
+func (rc *RemoteCluster) ListNetworkPolicies(ctx context.Context, labelSelector string) ([]v1net.NetworkPolicy, error) {
+	rc.informersMutex.Lock()
+	namespaces := make([]string, 0, len(rc.informerSets))
+
+	for namespace := range rc.informerSets {
+		namespaces = append(namespaces, namespace)
+	}
+	rc.informersMutex.Unlock()
+
+	sort.Strings(namespaces)
+
+	nps := []v1net.NetworkPolicy{}
+
+	for _, namespace := range namespaces {
+		list, err := rc.ClientSet.NetworkingV1().NetworkPolicies(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing the NetworkPolicies of namespace %q in cluster %s", namespace, rc.ClusterID)
+		}
+
+		nps = append(nps, list.Items...)
+	}
+
+	return nps, nil
+}
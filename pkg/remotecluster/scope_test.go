@@ -0,0 +1,184 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Cluster scopes", func() {
+	Context("Loading", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "scopes.yaml")
+		})
+
+		write := func(content string) {
+			Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		}
+
+		It("Should load the scope of each cluster and fall back to the '*' one", func() {
+			write(`
+clusters:
+  cluster-1:
+    namespaces: [payments, billing]
+    podSelector: "coastguard.io/peer=true"
+  "*":
+    namespaceSelector: "tenant in (a, b)"
+`)
+			scopes, err := LoadScopes(path)
+			Expect(err).ToNot(HaveOccurred())
+
+			scope := ScopeFor(scopes, "cluster-1")
+			Expect(scope.Namespaces).To(Equal([]string{"payments", "billing"}))
+			Expect(scope.String()).To(Equal(`namespaces payments, billing, pods matching "coastguard.io/peer=true"`))
+			Expect(ScopeFor(scopes, "cluster-2").NamespaceSelector).To(Equal("tenant in (a, b)"))
+		})
+
+		It("Should watch every namespace when no scope matches", func() {
+			scope := ScopeFor(nil, "cluster-1")
+			Expect(scope.IsLimited()).To(BeFalse())
+			Expect(scope.String()).To(Equal("all namespaces"))
+		})
+
+		It("Should reject namespaces combined with a namespace selector", func() {
+			write(`
+clusters:
+  cluster-1:
+    namespaces: [payments]
+    namespaceSelector: "tenant=a"
+`)
+			_, err := LoadScopes(path)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should reject invalid label selectors", func() {
+			write(`
+clusters:
+  cluster-1:
+    podSelector: "app in ("
+`)
+			_, err := LoadScopes(path)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should reject unknown fields", func() {
+			write(`
+clusters:
+  cluster-1:
+    namespace: payments
+`)
+			_, err := LoadScopes(path)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Watching", func() {
+		var (
+			eventChannel chan *Event
+			objects      []runtime.Object
+		)
+
+		BeforeEach(func() {
+			eventChannel = make(chan *Event, 10)
+			objects = []runtime.Object{
+				newScopedPod("payments", "api", map[string]string{"peer": "true"}),
+				newScopedPod("payments", "batch", nil),
+				newScopedPod("other", "api", map[string]string{"peer": "true"}),
+				newNamespace("payments", map[string]string{"tenant": "a"}),
+				newNamespace("other", nil),
+			}
+		})
+
+		run := func(scope Scope) *RemoteCluster {
+			remoteCluster := NewScoped(clusterID1, fake.NewSimpleClientset(objects...), scope)
+			remoteCluster.SetEventChannel(eventChannel)
+
+			done := make(chan bool)
+
+			remoteCluster.Run(func(*RemoteCluster) {
+				done <- true
+			})
+			Eventually(done).Should(Receive(BeTrue()))
+			DeferCleanup(remoteCluster.Stop)
+
+			return remoteCluster
+		}
+
+		podNames := func(remoteCluster *RemoteCluster) []string {
+			names := []string{}
+			for _, obj := range remoteCluster.GetPods() {
+				pod := obj.(*v1.Pod)
+				names = append(names, pod.Namespace+"/"+pod.Name)
+			}
+
+			return names
+		}
+
+		It("Should only watch the listed namespaces", func() {
+			remoteCluster := run(Scope{Namespaces: []string{"payments"}})
+			Expect(podNames(remoteCluster)).To(ConsistOf("payments/api", "payments/batch"))
+			Expect(remoteCluster.WatchedNamespaces()).To(Equal([]string{"payments"}))
+			Expect(remoteCluster.PodInformer()).To(BeNil())
+		})
+
+		It("Should only watch the selected pods", func() {
+			remoteCluster := run(Scope{PodSelector: "peer=true"})
+			Expect(podNames(remoteCluster)).To(ConsistOf("payments/api", "other/api"))
+		})
+
+		It("Should follow the namespaces matching the namespace selector", func() {
+			remoteCluster := run(Scope{NamespaceSelector: "tenant=a"})
+			Expect(podNames(remoteCluster)).To(ConsistOf("payments/api", "payments/batch"))
+
+			By("Deleting the selected namespace")
+			Expect(remoteCluster.ClientSet.CoreV1().Namespaces().Delete(context.TODO(), "payments",
+				metav1.DeleteOptions{})).To(Succeed())
+			Eventually(remoteCluster.WatchedNamespaces).Should(BeEmpty())
+			Expect(podNames(remoteCluster)).To(BeEmpty())
+
+			deleted := 0
+			for len(eventChannel) > 0 {
+				if event := <-eventChannel; event.Type == DeleteEvent {
+					deleted++
+				}
+			}
+
+			Expect(deleted).To(Equal(2))
+		})
+	})
+})
+
+func newScopedPod(namespace, name string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}}
+}
+
+func newNamespace(name string, labels map[string]string) *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
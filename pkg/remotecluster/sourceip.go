@@ -0,0 +1,110 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// SourceIPResolver maps the pods of a cluster to the source addresses other clusters
+// will actually see for their traffic, which may not be the pod IPs, i.e. when the
+// traffic leaves the cluster through an egress IP.
+type SourceIPResolver interface {
+	// SourceIPs returns the effective source addresses of the pod.
+	SourceIPs(pod *v1.Pod) []string
+
+	// Run starts watching whatever the resolver depends on until stopCh is closed,
+	// calling onChange every time the addresses it resolves may have changed.
+	Run(onChange func(), stopCh <-chan struct{})
+}
+
+// PodIPResolver is the default SourceIPResolver, which resolves pods to their own IPs.
+type PodIPResolver struct{}
+
+func (PodIPResolver) SourceIPs(pod *v1.Pod) []string {
+	if len(pod.Status.PodIPs) == 0 {
+		if pod.Status.PodIP == "" {
+			return nil
+		}
+
+		return []string{pod.Status.PodIP}
+	}
+
+	ips := make([]string, 0, len(pod.Status.PodIPs))
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+
+	return ips
+}
+
+func (PodIPResolver) Run(_ func(), _ <-chan struct{}) {}
+
+// SetSourceIPResolver replaces the PodIPResolver of the cluster, and starts the new
+// resolver, which will run until the cluster is stopped.
+func (rc *RemoteCluster) SetSourceIPResolver(resolver SourceIPResolver) {
+	rc.sourceIPResolverMutex.Lock()
+	rc.sourceIPResolver = resolver
+	rc.sourceIPResolverMutex.Unlock()
+
+	resolver.Run(rc.sourceIPsChanged, rc.stopCh)
+}
+
+// SourceIPs returns the source addresses other clusters see for the pod of this cluster.
+func (rc *RemoteCluster) SourceIPs(pod *v1.Pod) []string {
+	rc.sourceIPResolverMutex.Lock()
+	resolver := rc.sourceIPResolver
+	rc.sourceIPResolverMutex.Unlock()
+
+	if resolver == nil {
+		return PodIPResolver{}.SourceIPs(pod)
+	}
+
+	return resolver.SourceIPs(pod)
+}
+
+// sourceIPsChanged signals the change of the source IPs to the processing loop, without blocking the informers
+// of the resolver. The changes are coalesced until the processing loop handles the pending one, see
+// SourceIPsRefreshed.
+func (rc *RemoteCluster) sourceIPsChanged() {
+	rc.sourceIPResolverMutex.Lock()
+	pending := rc.sourceIPsPending
+	rc.sourceIPsPending = true
+	rc.sourceIPResolverMutex.Unlock()
+
+	if pending {
+		return
+	}
+
+	go rc.enqueueEvent(&Event{
+		Cluster: rc,
+		Type:    UpdateEvent,
+		ObjType: SourceIPs,
+		ObjID:   rc.ClusterID,
+	})
+}
+
+// SourceIPsRefreshed acknowledges the pending change of the source IPs, before refreshing the policies, so the
+// changes signalled from now on are sent again.
+func (rc *RemoteCluster) SourceIPsRefreshed() {
+	rc.sourceIPResolverMutex.Lock()
+	defer rc.sourceIPResolverMutex.Unlock()
+
+	rc.sourceIPsPending = false
+}
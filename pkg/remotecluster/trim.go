@@ -0,0 +1,72 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// keptPodAnnotations are the pod annotations which survive trimming, every other one is dropped.
+var keptPodAnnotations = map[string]bool{}
+
+// KeepPodAnnotations declares pod annotations we depend on, so they are kept in the trimmed pods,
+// it must be called before any cluster is created, i.e. from an init function.
+func KeepPodAnnotations(keys ...string) {
+	for _, key := range keys {
+		keptPodAnnotations[key] = true
+	}
+}
+
+// TrimPod is the pod informer transform, which strips pods down to the fields we use, so caching
+// the pods of every cluster takes a fraction of the memory: namespace, name, UID, labels, the annotations
+// declared with KeepPodAnnotations, phase and IPs.
+func TrimPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return obj, nil
+	}
+
+	trimmed := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       pod.Namespace,
+			Name:            pod.Name,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+			Labels:          pod.Labels,
+		},
+		Status: v1.PodStatus{
+			Phase:  pod.Status.Phase,
+			PodIP:  pod.Status.PodIP,
+			PodIPs: pod.Status.PodIPs,
+		},
+	}
+
+	for key, value := range pod.Annotations {
+		if keptPodAnnotations[key] {
+			if trimmed.Annotations == nil {
+				trimmed.Annotations = map[string]string{}
+			}
+
+			trimmed.Annotations[key] = value
+		}
+	}
+
+	return trimmed, nil
+}
@@ -0,0 +1,170 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remotecluster
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testKeptAnnotation = "test.coastguard/kept"
+
+var _ = Describe("Pod trimming", func() {
+	KeepPodAnnotations(testKeptAnnotation)
+
+	It("Should keep only the fields we use", func() {
+		pod := newRealisticPod(1)
+		pod.Annotations[testKeptAnnotation] = "kept"
+
+		obj, err := TrimPod(pod)
+		Expect(err).ToNot(HaveOccurred())
+
+		trimmed := obj.(*v1.Pod)
+		Expect(trimmed.Name).To(Equal(pod.Name))
+		Expect(trimmed.Namespace).To(Equal(pod.Namespace))
+		Expect(trimmed.UID).To(Equal(pod.UID))
+		Expect(trimmed.Labels).To(Equal(pod.Labels))
+		Expect(trimmed.Annotations).To(Equal(map[string]string{testKeptAnnotation: "kept"}))
+		Expect(trimmed.Status.Phase).To(Equal(pod.Status.Phase))
+		Expect(trimmed.Status.PodIPs).To(Equal(pod.Status.PodIPs))
+		Expect(PodIPResolver{}.SourceIPs(trimmed)).To(Equal(PodIPResolver{}.SourceIPs(pod)))
+		Expect(trimmed.Spec.Containers).To(BeEmpty())
+		Expect(trimmed.ManagedFields).To(BeEmpty())
+	})
+
+	It("Should leave other objects alone", func() {
+		ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Annotations: map[string]string{"a": "b"}}}
+		Expect(TrimPod(ns)).To(BeIdenticalTo(ns))
+	})
+})
+
+// BenchmarkPodCache reports the heap used to cache 10k pods, with and without trimming, run it with
+// go test ./pkg/remotecluster -run '^$' -bench PodCache.
+func BenchmarkPodCache(b *testing.B) {
+	const pods = 10000
+
+	for _, bench := range []struct {
+		name      string
+		transform cache.TransformFunc
+	}{
+		{"full", func(obj interface{}) (interface{}, error) { return obj, nil }},
+		{"trimmed", TrimPod},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var heapUsed uint64
+
+			for n := 0; n < b.N; n++ {
+				before := heapAlloc()
+
+				store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+				for i := 0; i < pods; i++ {
+					obj, _ := bench.transform(newRealisticPod(i))
+					_ = store.Add(obj)
+				}
+
+				heapUsed += heapAlloc() - before
+
+				runtime.KeepAlive(store)
+			}
+
+			b.ReportMetric(float64(heapUsed)/float64(b.N), "bytes/10k-pods")
+		})
+	}
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	return stats.HeapAlloc
+}
+
+// newRealisticPod returns a pod with the fields of a typical deployment pod, as received from the API server.
+func newRealisticPod(i int) *v1.Pod {
+	name := fmt.Sprintf("frontend-6d4cf56db6-%05d", i)
+	now := metav1.Now()
+
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			UID:               types.UID(fmt.Sprintf("8c4a2a4e-2d0b-4c8e-9a55-%012d", i)),
+			ResourceVersion:   fmt.Sprint(100000 + i),
+			CreationTimestamp: now,
+			GenerateName:      "frontend-6d4cf56db6-",
+			Labels:            map[string]string{"app": "frontend", "pod-template-hash": "6d4cf56db6"},
+			Annotations: map[string]string{
+				"kubectl.kubernetes.io/restartedAt": now.String(),
+				"prometheus.io/scrape":              "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "frontend-6d4cf56db6", UID: "0b8e5c7e-1f3a-4bb5-8c1d-6c7d1f0e2a11",
+			}},
+			ManagedFields: []metav1.ManagedFieldsEntry{{
+				Manager:    "kube-controller-manager",
+				Operation:  metav1.ManagedFieldsOperationUpdate,
+				APIVersion: "v1",
+				FieldsType: "FieldsV1",
+				FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:app":{}}},"f:spec":` + strings.Repeat(`{"f:containers":{}}`, 40) + `}`)},
+			}},
+		},
+		Spec: v1.PodSpec{
+			NodeName:           fmt.Sprintf("worker-%d", i%50),
+			ServiceAccountName: "default",
+			Containers: []v1.Container{{
+				Name:  "frontend",
+				Image: "quay.io/example/frontend:v1.2.3",
+				Ports: []v1.ContainerPort{{ContainerPort: 8080, Protocol: v1.ProtocolTCP}},
+				Env: []v1.EnvVar{
+					{Name: "LOG_LEVEL", Value: "info"},
+					{Name: "BACKEND_URL", Value: "http://backend.default.svc.cluster.local:8080"},
+				},
+				VolumeMounts: []v1.VolumeMount{{Name: "kube-api-access", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"}},
+			}},
+			Volumes: []v1.Volume{{Name: "kube-api-access"}},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			Conditions: []v1.PodCondition{
+				{Type: v1.PodReady, Status: v1.ConditionTrue, LastTransitionTime: now},
+				{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: now},
+			},
+			HostIP: "172.18.0.3",
+			PodIP:  fmt.Sprintf("10.1.%d.%d", i/250, i%250),
+			PodIPs: []v1.PodIP{{IP: fmt.Sprintf("10.1.%d.%d", i/250, i%250)}},
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name: "frontend", Ready: true, Image: "quay.io/example/frontend:v1.2.3",
+				ImageID:     "quay.io/example/frontend@sha256:" + strings.Repeat("ab", 32),
+				ContainerID: "containerd://" + strings.Repeat("cd", 32),
+				State:       v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: now}},
+			}},
+		},
+	}
+}
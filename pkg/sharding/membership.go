@@ -0,0 +1,163 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+)
+
+// The label of the Leases replicas use to announce their membership.
+const (
+	memberLabel      = "app.kubernetes.io/component"
+	memberLabelValue = "coastguard-shard-member"
+)
+
+// Membership announces a replica with a Lease on the hub cluster, which it renews periodically, and
+// follows the Leases of the other replicas. Replicas which stop renewing their Lease are dropped.
+type Membership struct {
+	ClientSet kubernetes.Interface
+	Namespace string
+	Identity  string
+
+	LeaseDuration time.Duration
+	RenewPeriod   time.Duration
+
+	members []string
+}
+
+func NewMembership(clientSet kubernetes.Interface, namespace, identity string) *Membership {
+	return &Membership{
+		ClientSet:     clientSet,
+		Namespace:     namespace,
+		Identity:      identity,
+		LeaseDuration: 30 * time.Second,
+		RenewPeriod:   10 * time.Second,
+	}
+}
+
+// Run renews our Lease and calls onChange with the sorted list of live members every time it changes,
+// until stopCh is closed, when our Lease is deleted so the others rebalance right away.
+func (m *Membership) Run(onChange func(members []string), stopCh <-chan struct{}) {
+	ticker := time.NewTicker(m.RenewPeriod)
+	defer ticker.Stop()
+
+	for {
+		m.sync(onChange)
+
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			err := m.ClientSet.CoordinationV1().Leases(m.Namespace).Delete(context.TODO(), m.leaseName(), metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				klog.ErrorS(err, "Unable to delete the shard membership Lease")
+			}
+
+			return
+		}
+	}
+}
+
+func (m *Membership) sync(onChange func(members []string)) {
+	if err := m.renew(); err != nil {
+		klog.ErrorS(err, "Unable to renew the shard membership Lease")
+	}
+
+	members, err := m.liveMembers()
+	if err != nil {
+		klog.ErrorS(err, "Unable to list the shard members")
+		return
+	}
+
+	if !reflect.DeepEqual(members, m.members) {
+		klog.InfoS("Shard members changed", "members", members)
+		m.members = members
+		onChange(members)
+	}
+}
+
+func (m *Membership) renew() error {
+	leases := m.ClientSet.CoordinationV1().Leases(m.Namespace)
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := leases.Get(context.TODO(), m.leaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(context.TODO(), &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   m.leaseName(),
+				Labels: map[string]string{memberLabel: memberLabelValue},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       pointer.String(m.Identity),
+				LeaseDurationSeconds: pointer.Int32(int32(m.LeaseDuration.Seconds())),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+
+		return errors.Wrap(err, "error creating the Lease")
+	} else if err != nil {
+		return errors.Wrap(err, "error getting the Lease")
+	}
+
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+
+	return errors.Wrap(err, "error updating the Lease")
+}
+
+func (m *Membership) liveMembers() ([]string, error) {
+	leases, err := m.ClientSet.CoordinationV1().Leases(m.Namespace).List(context.TODO(),
+		metav1.ListOptions{LabelSelector: memberLabel + "=" + memberLabelValue})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing the Leases")
+	}
+
+	members := []string{}
+
+	for i := range leases.Items {
+		spec := &leases.Items[i].Spec
+		if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+
+		expiry := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+		if time.Now().Before(expiry) {
+			members = append(members, *spec.HolderIdentity)
+		}
+	}
+
+	sort.Strings(members)
+
+	return members, nil
+}
+
+func (m *Membership) leaseName() string {
+	return "coastguard-shard-" + m.Identity
+}
@@ -0,0 +1,138 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/sharding"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("Ring", func() {
+	clusters := []string{}
+	for i := 0; i < 300; i++ {
+		clusters = append(clusters, fmt.Sprintf("cluster-%d", i))
+	}
+
+	owners := func(ring *sharding.Ring) map[string]string {
+		result := map[string]string{}
+		for _, cluster := range clusters {
+			result[cluster] = ring.Owner(cluster)
+		}
+
+		return result
+	}
+
+	It("Should own nothing without members", func() {
+		Expect(sharding.NewRing(nil).Owner("cluster-1")).To(BeEmpty())
+	})
+
+	It("Should spread the clusters across all the members", func() {
+		counts := map[string]int{}
+		for _, owner := range owners(sharding.NewRing([]string{"a", "b", "c"})) {
+			counts[owner]++
+		}
+
+		Expect(counts).To(HaveLen(3))
+
+		for _, count := range counts {
+			Expect(count).To(BeNumerically(">", 50))
+		}
+	})
+
+	It("Should not depend on the order of the members", func() {
+		Expect(owners(sharding.NewRing([]string{"a", "b", "c"}))).To(Equal(owners(sharding.NewRing([]string{"c", "a", "b"}))))
+	})
+
+	It("Should only move the clusters of the member which left", func() {
+		before := owners(sharding.NewRing([]string{"a", "b", "c"}))
+		after := owners(sharding.NewRing([]string{"a", "b"}))
+
+		for cluster, owner := range before {
+			if owner != "c" {
+				Expect(after[cluster]).To(Equal(owner))
+			}
+		}
+	})
+})
+
+var _ = Describe("Membership", func() {
+	var (
+		clientSet *fake.Clientset
+		stopCh    chan struct{}
+		changes   chan []string
+	)
+
+	BeforeEach(func() {
+		clientSet = fake.NewSimpleClientset()
+		stopCh = make(chan struct{})
+		changes = make(chan []string, 10)
+	})
+
+	run := func(identity string) {
+		membership := sharding.NewMembership(clientSet, "coastguard", identity)
+		membership.RenewPeriod = 50 * time.Millisecond
+
+		go membership.Run(func(members []string) { changes <- members }, stopCh)
+	}
+
+	It("Should follow the live members", func() {
+		expired := metav1.NewMicroTime(time.Now().Add(-time.Hour))
+		_, err := clientSet.CoordinationV1().Leases("coastguard").Create(context.TODO(), &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "coastguard-shard-gone",
+				Labels: map[string]string{"app.kubernetes.io/component": "coastguard-shard-member"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       pointer.String("gone"),
+				LeaseDurationSeconds: pointer.Int32(30),
+				RenewTime:            &expired,
+			},
+		}, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		run("replica-1")
+		Eventually(changes).Should(Receive(Equal([]string{"replica-1"})))
+
+		run("replica-2")
+		Eventually(changes).Should(Receive(Equal([]string{"replica-1", "replica-2"})))
+
+		close(stopCh)
+		Eventually(func() int {
+			leases, err := clientSet.CoordinationV1().Leases("coastguard").List(context.TODO(), metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			return len(leases.Items)
+		}).Should(Equal(1))
+	})
+})
+
+func TestSharding(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: Sharding suite")
+}
@@ -0,0 +1,346 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sourceip
+
+import (
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// The Calico annotations, on the client pod or its namespace, which select the egress gateways.
+const (
+	calicoEgressSelectorAnnotation          = "egress.projectcalico.org/selector"
+	calicoEgressNamespaceSelectorAnnotation = "egress.projectcalico.org/namespaceSelector"
+)
+
+func init() {
+	// the trimmed pods cached by the remote clusters must keep the pod egress selectors
+	remotecluster.KeepPodAnnotations(calicoEgressSelectorAnnotation, calicoEgressNamespaceSelectorAnnotation)
+}
+
+// CalicoEgressGatewayResolver resolves pods using Calico egress gateways to the IPs of the gateway pods.
+// Only the subset of the Calico selector syntax made of "&&" separated terms is supported: all(),
+// has(key), !has(key), key == 'value', key != 'value', key in {'a', 'b'} and key not in {'a', 'b'}.
+type CalicoEgressGatewayResolver struct {
+	podInformer       cache.SharedIndexInformer
+	namespaceInformer cache.SharedIndexInformer
+
+	// gatewaySelectors are the gateway selectors seen so far, so we know which pod changes
+	// may change the resolved source IPs
+	gatewaySelectorsMutex sync.Mutex
+	gatewaySelectors      map[string]labels.Selector
+	onChange              func()
+
+	// gatewayIPs caches the resolved gateway IPs by gateway and namespace selectors, it's invalidated
+	// whenever a gateway pod or a namespace changes, generation tells whether it was meanwhile
+	gatewayIPsMutex sync.Mutex
+	gatewayIPs      map[string][]string
+	generation      uint64
+}
+
+func NewCalicoEgressGatewayResolver(clientSet kubernetes.Interface, podInformer cache.SharedIndexInformer,
+) *CalicoEgressGatewayResolver {
+	return &CalicoEgressGatewayResolver{
+		podInformer:       podInformer,
+		namespaceInformer: newNamespaceInformer(clientSet),
+		gatewaySelectors:  map[string]labels.Selector{},
+		gatewayIPs:        map[string][]string{},
+	}
+}
+
+func (r *CalicoEgressGatewayResolver) Run(onChange func(), stopCh <-chan struct{}) {
+	r.onChange = onChange
+
+	_, _ = r.namespaceInformer.AddEventHandler(onMetadataChange(func() {
+		r.invalidateGatewayIPs()
+		onChange()
+	}))
+	_, _ = r.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: r.onPod,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, oldOK := oldObj.(*v1.Pod)
+			newPod, newOK := newObj.(*v1.Pod)
+
+			if oldOK && newOK && gatewayChanged(oldPod, newPod) && (r.isGateway(oldPod) || r.isGateway(newPod)) {
+				r.invalidateGatewayIPs()
+				r.onChange()
+			}
+		},
+		DeleteFunc: r.onPod,
+	})
+
+	go r.namespaceInformer.Run(stopCh)
+}
+
+// onPod signals a change when the pod may be an egress gateway.
+func (r *CalicoEgressGatewayResolver) onPod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+
+	if r.isGateway(pod) {
+		r.invalidateGatewayIPs()
+		r.onChange()
+	}
+}
+
+// gatewayChanged returns true if the update changes what a gateway pod resolves to: its labels, phase or IPs,
+// i.e. not its other status changes nor the resyncs.
+func gatewayChanged(oldPod, newPod *v1.Pod) bool {
+	return !maps.Equal(oldPod.Labels, newPod.Labels) || oldPod.Status.Phase != newPod.Status.Phase ||
+		!slices.Equal(remotecluster.PodIPResolver{}.SourceIPs(oldPod), remotecluster.PodIPResolver{}.SourceIPs(newPod))
+}
+
+func (r *CalicoEgressGatewayResolver) invalidateGatewayIPs() {
+	r.gatewayIPsMutex.Lock()
+	defer r.gatewayIPsMutex.Unlock()
+
+	r.gatewayIPs = map[string][]string{}
+	r.generation++
+}
+
+func (r *CalicoEgressGatewayResolver) isGateway(pod *v1.Pod) bool {
+	r.gatewaySelectorsMutex.Lock()
+	defer r.gatewaySelectorsMutex.Unlock()
+
+	for _, selector := range r.gatewaySelectors {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *CalicoEgressGatewayResolver) SourceIPs(pod *v1.Pod) []string {
+	gatewaySelector, namespaceSelector, err := r.gatewaySelectorsFor(pod)
+	if err != nil {
+		klog.ErrorS(err, "Unable to resolve the Calico egress gateways of the Pod", "namespace", pod.Namespace, "podName", pod.Name)
+	}
+
+	if gatewaySelector == nil {
+		return remotecluster.PodIPResolver{}.SourceIPs(pod)
+	}
+
+	// gateways live in the client namespace when there's no namespace selector
+	key := gatewaySelector.String() + "/" + pod.Namespace
+	if namespaceSelector != nil {
+		key = gatewaySelector.String() + "/" + namespaceSelector.String()
+	}
+
+	r.gatewayIPsMutex.Lock()
+	ips, cached := r.gatewayIPs[key]
+	generation := r.generation
+	r.gatewayIPsMutex.Unlock()
+
+	if cached {
+		return ips
+	}
+
+	ips = r.resolveGatewayIPs(pod.Namespace, gatewaySelector, namespaceSelector)
+
+	r.gatewayIPsMutex.Lock()
+	if generation == r.generation {
+		r.gatewayIPs[key] = ips
+	}
+	r.gatewayIPsMutex.Unlock()
+
+	return ips
+}
+
+// resolveGatewayIPs returns the IPs of the running gateway pods, which is a scan of every pod.
+func (r *CalicoEgressGatewayResolver) resolveGatewayIPs(namespace string, gatewaySelector, namespaceSelector labels.Selector,
+) []string {
+	ips := []string{}
+
+	for _, obj := range r.podInformer.GetStore().List() {
+		gateway := obj.(*v1.Pod)
+		if gateway.Status.Phase != v1.PodRunning || !gatewaySelector.Matches(labels.Set(gateway.Labels)) {
+			continue
+		}
+
+		if namespaceSelector == nil && gateway.Namespace != namespace ||
+			namespaceSelector != nil && !namespaceSelector.Matches(labels.Set(namespaceLabels(r.namespaceInformer, gateway.Namespace))) {
+			continue
+		}
+
+		ips = append(ips, remotecluster.PodIPResolver{}.SourceIPs(gateway)...)
+	}
+
+	// traffic is balanced over all the gateways, any of them can be the source
+	sort.Strings(ips)
+
+	// the IPs are shared by the callers, which may append to them
+	return ips[:len(ips):len(ips)]
+}
+
+// gatewaySelectorsFor returns the gateway pod and namespace selectors for the pod, from the pod
+// annotations first, then its namespace annotations. A nil gateway selector means no egress gateway
+// is used, and a nil namespace selector means the gateways live in the pod namespace.
+func (r *CalicoEgressGatewayResolver) gatewaySelectorsFor(pod *v1.Pod) (labels.Selector, labels.Selector, error) {
+	annotations := pod.Annotations
+
+	if _, exists := annotations[calicoEgressSelectorAnnotation]; !exists {
+		obj, exists, err := r.namespaceInformer.GetStore().GetByKey(pod.Namespace)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error getting namespace %s", pod.Namespace)
+		}
+
+		if !exists {
+			return nil, nil, nil
+		}
+
+		annotations = obj.(*v1.Namespace).Annotations
+	}
+
+	expression, exists := annotations[calicoEgressSelectorAnnotation]
+	if !exists {
+		return nil, nil, nil
+	}
+
+	gatewaySelector, err := r.parseGatewaySelector(expression)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expression, exists = annotations[calicoEgressNamespaceSelectorAnnotation]
+	if !exists {
+		return gatewaySelector, nil, nil
+	}
+
+	namespaceSelector, err := parseCalicoSelector(expression)
+
+	return gatewaySelector, namespaceSelector, err
+}
+
+func (r *CalicoEgressGatewayResolver) parseGatewaySelector(expression string) (labels.Selector, error) {
+	r.gatewaySelectorsMutex.Lock()
+	defer r.gatewaySelectorsMutex.Unlock()
+
+	if selector, exists := r.gatewaySelectors[expression]; exists {
+		return selector, nil
+	}
+
+	selector, err := parseCalicoSelector(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	r.gatewaySelectors[expression] = selector
+
+	return selector, nil
+}
+
+func parseCalicoSelector(expression string) (labels.Selector, error) {
+	selector := labels.NewSelector()
+
+	for _, term := range strings.Split(expression, "&&") {
+		term = strings.TrimSpace(term)
+		if term == "all()" {
+			continue
+		}
+
+		requirement, err := parseCalicoTerm(term)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unsupported Calico selector %q", expression)
+		}
+
+		selector = selector.Add(*requirement)
+	}
+
+	return selector, nil
+}
+
+func parseCalicoTerm(term string) (*labels.Requirement, error) {
+	if key, found := cutParenthesis(term, "!has("); found {
+		return labels.NewRequirement(key, selection.DoesNotExist, nil)
+	}
+
+	if key, found := cutParenthesis(term, "has("); found {
+		return labels.NewRequirement(key, selection.Exists, nil)
+	}
+
+	for _, operator := range []struct {
+		token string
+		op    selection.Operator
+	}{
+		{" not in ", selection.NotIn},
+		{" in ", selection.In},
+		{"==", selection.Equals},
+		{"!=", selection.NotEquals},
+	} {
+		key, value, found := strings.Cut(term, operator.token)
+		if !found {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if operator.op == selection.In || operator.op == selection.NotIn {
+			if !strings.HasPrefix(value, "{") || !strings.HasSuffix(value, "}") {
+				return nil, errors.Errorf("invalid set in %q", term)
+			}
+
+			values := []string{}
+			for _, v := range strings.Split(strings.Trim(value, "{}"), ",") {
+				values = append(values, unquote(strings.TrimSpace(v)))
+			}
+
+			return labels.NewRequirement(key, operator.op, values)
+		}
+
+		return labels.NewRequirement(key, operator.op, []string{unquote(value)})
+	}
+
+	return nil, errors.Errorf("unsupported term %q", term)
+}
+
+func cutParenthesis(term, prefix string) (string, bool) {
+	if !strings.HasPrefix(term, prefix) || !strings.HasSuffix(term, ")") {
+		return "", false
+	}
+
+	return strings.TrimSpace(term[len(prefix) : len(term)-1]), true
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
@@ -0,0 +1,131 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sourceip
+
+import (
+	"sort"
+
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+var EgressIPGVR = schema.GroupVersionResource{Group: "k8s.ovn.org", Version: "v1", Resource: "egressips"}
+
+// egressIP is the subset of the OVN-Kubernetes EgressIP resource we need.
+type egressIP struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		EgressIPs         []string             `json:"egressIPs"`
+		NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+		PodSelector       metav1.LabelSelector `json:"podSelector"`
+	} `json:"spec"`
+	Status struct {
+		Items []struct {
+			EgressIP string `json:"egressIP"`
+		} `json:"items"`
+	} `json:"status"`
+}
+
+// OVNEgressIPResolver resolves pods selected by an OVN-Kubernetes EgressIP to its egress IPs.
+type OVNEgressIPResolver struct {
+	egressIPInformer  cache.SharedIndexInformer
+	namespaceInformer cache.SharedIndexInformer
+}
+
+func NewOVNEgressIPResolver(clientSet kubernetes.Interface, dynamicClient dynamic.Interface) *OVNEgressIPResolver {
+	return &OVNEgressIPResolver{
+		egressIPInformer: dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, resyncTime).
+			ForResource(EgressIPGVR).Informer(),
+		namespaceInformer: newNamespaceInformer(clientSet),
+	}
+}
+
+func (r *OVNEgressIPResolver) Run(onChange func(), stopCh <-chan struct{}) {
+	_, _ = r.egressIPInformer.AddEventHandler(onAnyChange(onChange))
+	_, _ = r.namespaceInformer.AddEventHandler(onMetadataChange(onChange))
+
+	go r.egressIPInformer.Run(stopCh)
+	go r.namespaceInformer.Run(stopCh)
+}
+
+func (r *OVNEgressIPResolver) SourceIPs(pod *v1.Pod) []string {
+	namespaceLabels := labels.Set(namespaceLabels(r.namespaceInformer, pod.Namespace))
+
+	for _, eip := range r.egressIPs() {
+		if !selectorMatches(&eip.Spec.NamespaceSelector, namespaceLabels) ||
+			!selectorMatches(&eip.Spec.PodSelector, labels.Set(pod.Labels)) {
+			continue
+		}
+
+		ips := []string{}
+		for _, item := range eip.Status.Items {
+			ips = append(ips, item.EgressIP)
+		}
+
+		if len(ips) == 0 {
+			// the egress IPs are not assigned to any node yet
+			ips = eip.Spec.EgressIPs
+		}
+
+		return ips
+	}
+
+	return remotecluster.PodIPResolver{}.SourceIPs(pod)
+}
+
+// egressIPs returns the EgressIPs sorted by name, so the first match is always the same one.
+func (r *OVNEgressIPResolver) egressIPs() []*egressIP {
+	eips := []*egressIP{}
+
+	for _, obj := range r.egressIPInformer.GetStore().List() {
+		eip := &egressIP{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.(*unstructured.Unstructured).Object, eip); err != nil {
+			klog.ErrorS(err, "Ignoring EgressIP which can't be parsed")
+			continue
+		}
+
+		eips = append(eips, eip)
+	}
+
+	sort.Slice(eips, func(i, j int) bool {
+		return eips[i].Name < eips[j].Name
+	})
+
+	return eips
+}
+
+func selectorMatches(labelSelector *metav1.LabelSelector, set labels.Set) bool {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		klog.ErrorS(err, "Ignoring invalid label selector", "selector", labelSelector)
+		return false
+	}
+
+	return selector.Matches(set)
+}
@@ -0,0 +1,171 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sourceip
+
+import (
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The kinds of source IP resolvers which can be configured for a cluster.
+const (
+	PodIP               = "pod-ip"
+	OVNEgressIP         = "ovn-egressip"
+	CalicoEgressGateway = "calico-egress-gateway"
+)
+
+// AllClusters is the key used to configure the source IP resolver of every cluster
+// which has no specific configuration.
+const AllClusters = "*"
+
+const resyncTime = time.Hour * 24
+
+// New creates the kind of source IP resolver for a cluster.
+func New(kind string, clientSet kubernetes.Interface, dynamicClient dynamic.Interface,
+	podInformer cache.SharedIndexInformer,
+) (remotecluster.SourceIPResolver, error) {
+	switch kind {
+	case PodIP:
+		return remotecluster.PodIPResolver{}, nil
+	case OVNEgressIP:
+		return NewOVNEgressIPResolver(clientSet, dynamicClient), nil
+	case CalicoEgressGateway:
+		if podInformer == nil {
+			return nil, errors.New("the calico-egress-gateway resolver needs the pods of every namespace")
+		}
+
+		return NewCalicoEgressGatewayResolver(clientSet, podInformer), nil
+	}
+
+	return nil, errors.Errorf("unknown source IP resolver %q", kind)
+}
+
+// RequiredPermissions returns the cluster-wide permissions the kind of resolver needs, on top of those of the
+// cluster scope.
+func RequiredPermissions(kind string) []remotecluster.Permission {
+	namespaces := []remotecluster.Permission{{Verb: "list", Resource: "namespaces"}, {Verb: "watch", Resource: "namespaces"}}
+
+	switch kind {
+	case OVNEgressIP:
+		return append(namespaces, remotecluster.Permission{Verb: "list", Group: EgressIPGVR.Group, Resource: EgressIPGVR.Resource},
+			remotecluster.Permission{Verb: "watch", Group: EgressIPGVR.Group, Resource: EgressIPGVR.Resource})
+	case CalicoEgressGateway:
+		return namespaces
+	}
+
+	return nil
+}
+
+// ParseKinds parses a comma separated list of clusterID=kind entries, where the "*" clusterID
+// configures the resolver of every cluster without a specific entry.
+func ParseKinds(value string) (map[string]string, error) {
+	kinds := map[string]string{}
+
+	if value == "" {
+		return kinds, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		clusterID, kind, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || clusterID == "" {
+			return nil, errors.Errorf("invalid source IP resolver entry %q, expected clusterID=kind", entry)
+		}
+
+		switch kind {
+		case PodIP, OVNEgressIP, CalicoEgressGateway:
+		default:
+			return nil, errors.Errorf("unknown source IP resolver %q for cluster %q", kind, clusterID)
+		}
+
+		kinds[clusterID] = kind
+	}
+
+	return kinds, nil
+}
+
+// KindFor returns the kind of resolver configured for clusterID.
+func KindFor(kinds map[string]string, clusterID string) string {
+	if kind, exists := kinds[clusterID]; exists {
+		return kind
+	}
+
+	if kind, exists := kinds[AllClusters]; exists {
+		return kind
+	}
+
+	return PodIP
+}
+
+func newNamespaceInformer(clientSet kubernetes.Interface) cache.SharedIndexInformer {
+	return informers.NewSharedInformerFactory(clientSet, resyncTime).Core().V1().Namespaces().Informer()
+}
+
+func namespaceLabels(namespaceInformer cache.SharedIndexInformer, name string) map[string]string {
+	obj, exists, err := namespaceInformer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return nil
+	}
+
+	return obj.(*v1.Namespace).Labels
+}
+
+// onAnyChange is an event handler calling onChange on every add and delete, and on the updates which aren't
+// resyncs, i.e. which change the resource version.
+func onAnyChange(onChange func()) cache.ResourceEventHandlerFuncs {
+	return onChangeOf(onChange, func(_, _ metav1.Object) bool {
+		return true
+	})
+}
+
+// onMetadataChange is an event handler calling onChange on every add and delete, and on the updates changing the
+// labels or annotations.
+func onMetadataChange(onChange func()) cache.ResourceEventHandlerFuncs {
+	return onChangeOf(onChange, func(oldObj, newObj metav1.Object) bool {
+		return !maps.Equal(oldObj.GetLabels(), newObj.GetLabels()) || !maps.Equal(oldObj.GetAnnotations(), newObj.GetAnnotations())
+	})
+}
+
+// onChangeOf is an event handler calling onChange on every add and delete, and on the updates changing the
+// resource version for which changed returns true.
+func onChangeOf(onChange func(), changed func(oldObj, newObj metav1.Object) bool) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(_ interface{}) { onChange() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, oldErr := meta.Accessor(oldObj)
+			newMeta, newErr := meta.Accessor(newObj)
+
+			if oldErr != nil || newErr != nil ||
+				oldMeta.GetResourceVersion() != newMeta.GetResourceVersion() && changed(oldMeta, newMeta) {
+				onChange()
+			}
+		},
+		DeleteFunc: func(_ interface{}) { onChange() },
+	}
+}
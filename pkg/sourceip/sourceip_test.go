@@ -0,0 +1,305 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sourceip
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/remotecluster"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+)
+
+const (
+	testNamespace = "payments"
+	testClusterID = "cluster-1"
+)
+
+var _ = Describe("Source IP resolvers", func() {
+	klog.InitFlags(nil)
+
+	Describe("Configuration", describeConfiguration)
+	Describe("OVN EgressIP resolver", describeOVNEgressIPResolver)
+	Describe("Calico egress gateway resolver", describeCalicoEgressGatewayResolver)
+	Describe("Calico selectors", describeCalicoSelectors)
+	Describe("Change signals", describeChangeSignals)
+})
+
+func describeConfiguration() {
+	It("Should parse per cluster and default resolvers", func() {
+		kinds, err := ParseKinds("*=ovn-egressip, cluster-2=pod-ip")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(KindFor(kinds, "cluster-1")).To(Equal(OVNEgressIP))
+		Expect(KindFor(kinds, "cluster-2")).To(Equal(PodIP))
+		Expect(KindFor(map[string]string{}, "cluster-1")).To(Equal(PodIP))
+	})
+
+	It("Should refuse unknown resolvers", func() {
+		_, err := ParseKinds("cluster-1=unknown")
+		Expect(err).To(HaveOccurred())
+	})
+}
+
+func describeOVNEgressIPResolver() {
+	var (
+		resolver *OVNEgressIPResolver
+		stopCh   chan struct{}
+		changes  chan bool
+	)
+
+	BeforeEach(func() {
+		stopCh = make(chan struct{})
+		changes = make(chan bool, 100)
+
+		namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace, Labels: map[string]string{"egress": "yes"}}}
+		dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{EgressIPGVR: "EgressIPList"},
+			newEgressIP("eip-1", map[string]string{"egress": "yes"}, map[string]string{"app": "billing"}, "172.18.0.100"))
+
+		resolver = NewOVNEgressIPResolver(fake.NewSimpleClientset(namespace), dynamicClient)
+		resolver.Run(func() { changes <- true }, stopCh)
+		Eventually(changes).Should(Receive())
+		Eventually(func() bool {
+			return resolver.egressIPInformer.HasSynced() && resolver.namespaceInformer.HasSynced()
+		}).Should(BeTrue())
+	})
+
+	AfterEach(func() {
+		close(stopCh)
+	})
+
+	It("Should resolve selected pods to the egress IPs", func() {
+		Eventually(func() []string {
+			return resolver.SourceIPs(newPod(testNamespace, "client", map[string]string{"app": "billing"}, "10.1.0.5"))
+		}).Should(Equal([]string{"172.18.0.100"}))
+	})
+
+	It("Should resolve non selected pods to the pod IPs", func() {
+		Expect(resolver.SourceIPs(newPod(testNamespace, "client", map[string]string{"app": "other"}, "10.1.0.5"))).
+			To(Equal([]string{"10.1.0.5"}))
+		Expect(resolver.SourceIPs(newPod("other", "client", map[string]string{"app": "billing"}, "10.1.0.6"))).
+			To(Equal([]string{"10.1.0.6"}))
+	})
+}
+
+func describeCalicoEgressGatewayResolver() {
+	var (
+		resolver   *CalicoEgressGatewayResolver
+		rc         *remotecluster.RemoteCluster
+		clientSet  *fake.Clientset
+		stopCh     chan struct{}
+		changes    chan bool
+		namespaces []*v1.Namespace
+		pods       []*v1.Pod
+	)
+
+	BeforeEach(func() {
+		stopCh = make(chan struct{})
+		changes = make(chan bool, 100)
+		namespaces = []*v1.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: testNamespace}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "gateways", Labels: map[string]string{"role": "gateways"}}},
+		}
+
+		gateway := newPod("gateways", "gateway-1", map[string]string{"egress-code": "red"}, "10.1.9.1")
+		gateway.Status.Phase = v1.PodRunning
+		pods = []*v1.Pod{gateway}
+	})
+
+	JustBeforeEach(func() {
+		objs := []runtime.Object{}
+		for _, ns := range namespaces {
+			objs = append(objs, ns)
+		}
+
+		for _, pod := range pods {
+			objs = append(objs, pod)
+		}
+
+		clientSet = fake.NewSimpleClientset(objs...)
+		rc = remotecluster.New(testClusterID, clientSet)
+		resolver = NewCalicoEgressGatewayResolver(clientSet, rc.PodInformer())
+		resolver.Run(func() { changes <- true }, stopCh)
+		rc.Run(nil)
+
+		Eventually(func() bool {
+			return rc.HasSynced() && resolver.namespaceInformer.HasSynced()
+		}).Should(BeTrue())
+	})
+
+	AfterEach(func() {
+		close(stopCh)
+		rc.Stop()
+	})
+
+	When("the client pod is annotated", func() {
+		It("Should resolve it to the gateway IPs", func() {
+			client := newPod(testNamespace, "client", nil, "10.1.0.5")
+			client.Annotations = map[string]string{
+				calicoEgressSelectorAnnotation:          "egress-code == 'red'",
+				calicoEgressNamespaceSelectorAnnotation: "role == 'gateways'",
+			}
+
+			Expect(resolver.SourceIPs(client)).To(Equal([]string{"10.1.9.1"}))
+		})
+
+		It("Should follow the gateway pods", func() {
+			client := newPod(testNamespace, "client", nil, "10.1.0.5")
+			client.Annotations = map[string]string{
+				calicoEgressSelectorAnnotation:          "egress-code == 'red'",
+				calicoEgressNamespaceSelectorAnnotation: "role == 'gateways'",
+			}
+
+			Expect(resolver.SourceIPs(client)).To(Equal([]string{"10.1.9.1"}))
+
+			gateway := newPod("gateways", "gateway-2", map[string]string{"egress-code": "red"}, "10.1.9.2")
+			gateway.Status.Phase = v1.PodRunning
+			_, err := clientSet.CoreV1().Pods("gateways").Create(context.TODO(), gateway, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(func() []string {
+				return resolver.SourceIPs(client)
+			}).Should(Equal([]string{"10.1.9.1", "10.1.9.2"}))
+		})
+
+		It("Should look for gateways in the client namespace when there is no namespace selector", func() {
+			client := newPod(testNamespace, "client", nil, "10.1.0.5")
+			client.Annotations = map[string]string{calicoEgressSelectorAnnotation: "egress-code == 'red'"}
+
+			Expect(resolver.SourceIPs(client)).To(BeEmpty())
+		})
+	})
+
+	When("the client namespace is annotated", func() {
+		BeforeEach(func() {
+			namespaces[0].Annotations = map[string]string{
+				calicoEgressSelectorAnnotation:          "egress-code == 'red'",
+				calicoEgressNamespaceSelectorAnnotation: "has(role)",
+			}
+		})
+
+		It("Should resolve its pods to the gateway IPs", func() {
+			Expect(resolver.SourceIPs(newPod(testNamespace, "client", nil, "10.1.0.5"))).To(Equal([]string{"10.1.9.1"}))
+		})
+	})
+
+	It("Should resolve pods without egress gateway to the pod IPs", func() {
+		Expect(resolver.SourceIPs(newPod(testNamespace, "client", nil, "10.1.0.5"))).To(Equal([]string{"10.1.0.5"}))
+	})
+}
+
+func describeChangeSignals() {
+	It("Should not signal the resyncs nor the namespace updates keeping the labels and annotations", func() {
+		changes := 0
+		handler := onMetadataChange(func() { changes++ })
+
+		namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace, ResourceVersion: "1"}}
+		handler.OnUpdate(namespace, namespace)
+
+		updated := namespace.DeepCopy()
+		updated.ResourceVersion = "2"
+		updated.Status.Phase = v1.NamespaceTerminating
+		handler.OnUpdate(namespace, updated)
+		Expect(changes).To(Equal(0))
+
+		relabeled := updated.DeepCopy()
+		relabeled.ResourceVersion = "3"
+		relabeled.Labels = map[string]string{"egress": "yes"}
+		handler.OnUpdate(updated, relabeled)
+		Expect(changes).To(Equal(1))
+	})
+
+	It("Should only consider the labels, phase and IPs of the gateway pods", func() {
+		gateway := newPod("gateways", "gateway-1", map[string]string{"egress-code": "red"}, "10.1.9.1")
+		updated := gateway.DeepCopy()
+		updated.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+		Expect(gatewayChanged(gateway, updated)).To(BeFalse())
+
+		updated.Status.Phase = v1.PodRunning
+		Expect(gatewayChanged(gateway, updated)).To(BeTrue())
+	})
+}
+
+func describeCalicoSelectors() {
+	DescribeTable("Should translate the supported subset to label selectors",
+		func(expression string, set map[string]string, matches bool) {
+			selector, err := parseCalicoSelector(expression)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(selector.Matches(labels.Set(set))).To(Equal(matches))
+		},
+		Entry("equality", "a == 'x'", map[string]string{"a": "x"}, true),
+		Entry("inequality", "a != \"x\"", map[string]string{"a": "x"}, false),
+		Entry("has", "has(a) && !has(b)", map[string]string{"a": "x"}, true),
+		Entry("in", "a in {'x', 'y'}", map[string]string{"a": "y"}, true),
+		Entry("not in", "a not in {'x', 'y'}", map[string]string{"a": "y"}, false),
+		Entry("all", "all()", map[string]string{}, true),
+	)
+
+	It("Should refuse unsupported expressions", func() {
+		_, err := parseCalicoSelector("a == 'x' || b == 'y'")
+		Expect(err).To(HaveOccurred())
+	})
+}
+
+func newEgressIP(name string, namespaceLabels, podLabels map[string]string, ip string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "k8s.ovn.org/v1",
+		"kind":       "EgressIP",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"egressIPs":         []interface{}{ip},
+			"namespaceSelector": map[string]interface{}{"matchLabels": toInterfaceMap(namespaceLabels)},
+			"podSelector":       map[string]interface{}{"matchLabels": toInterfaceMap(podLabels)},
+		},
+		"status": map[string]interface{}{
+			"items": []interface{}{map[string]interface{}{"node": "node-1", "egressIP": ip}},
+		},
+	}}
+}
+
+func toInterfaceMap(m map[string]string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range m {
+		result[k] = v
+	}
+
+	return result
+}
+
+func newPod(namespace, name string, podLabels map[string]string, ip string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: podLabels},
+		Status:     v1.PodStatus{PodIP: ip},
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: Source IP resolvers suite")
+}
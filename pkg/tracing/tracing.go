@@ -0,0 +1,118 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters of the spans.
+const (
+	// ExporterNone disables tracing, the spans are never recorded
+	ExporterNone = ""
+	// ExporterOTLP sends the spans to an OTLP collector over gRPC, configured with the
+	// standard OTEL_EXPORTER_OTLP_* environment variables
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes the spans as JSON, for local testing
+	ExporterStdout = "stdout"
+)
+
+const (
+	instrumentationName = "github.com/submariner-io/coastguard"
+	serviceName         = "coastguard"
+)
+
+// Attribute keys of the spans.
+const (
+	ClusterKey   = attribute.Key("coastguard.cluster")
+	PolicyKey    = attribute.Key("coastguard.policy")
+	PodKey       = attribute.Key("coastguard.pod")
+	ObjectKey    = attribute.Key("coastguard.object")
+	EventTypeKey = attribute.Key("coastguard.event.type")
+	ObjTypeKey   = attribute.Key("coastguard.object.type")
+	NamespaceKey = attribute.Key("k8s.namespace.name")
+	NameKey      = attribute.Key("k8s.networkpolicy.name")
+)
+
+// Setup installs the tracer provider exporting the spans with exporter, sampling the ratio of the
+// traces started by coastguard. The returned function flushes and stops the exporter.
+func Setup(exporter string, sampleRatio float64, out io.Writer) (func(context.Context) error, error) {
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return nil, errors.Errorf("invalid tracing sample ratio %v, expected between 0 and 1", sampleRatio)
+	}
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracegrpc.New(context.Background())
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	default:
+		return nil, errors.Errorf("unknown tracing exporter %q, expected %q or %q", exporter, ExporterOTLP, ExporterStdout)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating the %s tracing exporter", exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as child of the span in ctx, a new trace is started when there's none.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Context returns a context holding parent, for the spans continuing a trace across the event queue.
+func Context(parent trace.SpanContext) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), parent)
+}
+
+// End ends the span, recording err if any.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,80 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+
+Copyright Contributors to the Submariner project.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/submariner-io/coastguard/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var _ = Describe("Tracing", func() {
+	AfterEach(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+
+	It("Should record nothing when disabled", func() {
+		shutdown, err := tracing.Setup(tracing.ExporterNone, 1, nil)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(shutdown, context.TODO())
+
+		_, span := tracing.Start(context.TODO(), "test")
+		Expect(span.IsRecording()).To(BeFalse())
+		Expect(span.SpanContext().IsValid()).To(BeFalse())
+	})
+
+	It("Should write the spans to stdout, and continue traces across the queue", func() {
+		out := &bytes.Buffer{}
+		shutdown, err := tracing.Setup(tracing.ExporterStdout, 1, out)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, parent := tracing.Start(context.TODO(), "RemoteCluster.OnAdd", tracing.PodKey.String("pod1"))
+		parent.End()
+
+		_, child := tracing.Start(tracing.Context(parent.SpanContext()), "RemoteCluster.Distribute")
+		Expect(child.SpanContext().TraceID()).To(Equal(parent.SpanContext().TraceID()))
+		tracing.End(child, errors.New("forbidden"))
+
+		Expect(shutdown(context.TODO())).To(Succeed())
+		Expect(out.String()).To(ContainSubstring(`"Name":"RemoteCluster.OnAdd"`))
+		Expect(out.String()).To(ContainSubstring(`"Name":"RemoteCluster.Distribute"`))
+		Expect(out.String()).To(ContainSubstring(`"Value":"pod1"`))
+		Expect(out.String()).To(ContainSubstring(`"Description":"forbidden"`))
+	})
+
+	It("Should refuse unknown exporters and sample ratios", func() {
+		_, err := tracing.Setup("zipkin", 1, nil)
+		Expect(err).To(HaveOccurred())
+
+		_, err = tracing.Setup(tracing.ExporterStdout, 1.5, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func TestTracing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coastguard: Tracing suite")
+}